@@ -1,13 +1,120 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"strings"
 
+	"github.com/alexflint/go-arg"
+
+	"github.com/azoghal/sonostalgia/src/devserver"
 	"github.com/azoghal/sonostalgia/src/templater"
 )
 
+type BuildCmd struct{}
+
+type LintCmd struct{}
+
+type RollbackCmd struct{}
+
+type AssetsCmd struct {
+	Delete bool   `arg:"--delete" help:"delete orphaned assets"`
+	Trash  string `arg:"--trash"  help:"move orphaned assets into this directory instead of deleting them"`
+}
+
+type ServeCmd struct {
+	Addr string `arg:"--addr" default:"localhost:8080" help:"address to serve the site on"`
+}
+
+type Args struct {
+	Build    *BuildCmd    `arg:"subcommand:build"    help:"render the site (the default)"`
+	Lint     *LintCmd     `arg:"subcommand:lint"     help:"check memory files for problems without rendering"`
+	Serve    *ServeCmd    `arg:"subcommand:serve"    help:"serve the site, rebuilding and reloading the browser on changes"`
+	Rollback *RollbackCmd `arg:"subcommand:rollback" help:"put the previous build back in place of the current one"`
+	Assets   *AssetsCmd   `arg:"subcommand:assets"   help:"list assets nothing links to, links to missing assets and duplicated assets"`
+
+	Src     string `arg:"--src"    default:"src"    help:"directory containing memories, templates and assets"`
+	Output  string `arg:"--output" default:"output" help:"directory to write the site to"`
+	Force   bool   `arg:"--force"                   help:"ignore the build manifest and rebuild everything"`
+	Workers int    `arg:"--workers"                 help:"how many pages to render at once (default: one per CPU)"`
+
+	SiteURL string `arg:"--site-url,env:SITE_URL" help:"URL the site is published at, used for absolute links in the feeds"`
+}
+
 func main() {
-	if err := templater.Run("src", "output"); err != nil {
+	var args Args
+	p := arg.MustParse(&args)
+
+	switch cmd := p.Subcommand().(type) {
+	case *LintCmd:
+		runLint(args)
+	case *AssetsCmd:
+		if cmd.Delete && cmd.Trash != "" {
+			p.Fail("--delete and --trash can't be used together")
+		}
+		runAssets(args, cmd)
+	case *RollbackCmd:
+		build, err := templater.Rollback(args.Output)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("%s now shows build %s\n", args.Output, build)
+	case *ServeCmd:
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		if err := devserver.Serve(ctx, args.Src, args.Output, cmd.Addr); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		if err := templater.Run(args.Src, args.Output, templater.Options{Force: args.Force, Workers: args.Workers, SiteURL: args.SiteURL}); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+func runLint(args Args) {
+	problems, err := templater.Lint(args.Src)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, problem := range problems {
+		fmt.Println(problem)
+	}
+	if len(problems) > 0 {
+		fmt.Printf("\n%d problems found\n", len(problems))
+		os.Exit(1)
+	}
+	fmt.Println("no problems found")
+}
+
+func runAssets(args Args, cmd *AssetsCmd) {
+	report, err := templater.Assets(args.Src)
+	if err != nil {
 		log.Fatal(err)
 	}
+	for _, ref := range report.Missing {
+		fmt.Printf("%s doesn't exist\n", ref)
+	}
+	for _, group := range report.Duplicates {
+		fmt.Printf("identical: %s\n", strings.Join(group, ", "))
+	}
+	for _, orphan := range report.Orphans {
+		fmt.Printf("not linked to: %s\n", orphan)
+	}
+	fmt.Printf("\n%d missing, %d sets of duplicates, %d orphaned\n", len(report.Missing), len(report.Duplicates), len(report.Orphans))
+
+	if len(report.Orphans) == 0 || (!cmd.Delete && cmd.Trash == "") {
+		return
+	}
+	if err := templater.RemoveAssets(args.Src, report.Orphans, cmd.Trash); err != nil {
+		log.Fatal(err)
+	}
+	if cmd.Trash != "" {
+		fmt.Printf("moved %d orphaned assets to %s\n", len(report.Orphans), cmd.Trash)
+	} else {
+		fmt.Printf("deleted %d orphaned assets\n", len(report.Orphans))
+	}
 }
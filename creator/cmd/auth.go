@@ -1,17 +1,187 @@
 package main
 
 import (
+	"context"
 	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
 	"net/http"
+	"net/url"
+	"os"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
-const cookieName = "session"
+const (
+	cookieName      = "session"
+	csrfHeader      = "X-CSRF-Token"
+	sessionLifetime = 7 * 24 * time.Hour
+	// revokedSessionsPath keeps logouts across restarts, until the sessions would have expired anyway.
+	revokedSessionsPath = ".creator-sessions.json"
+)
+
+// sessionStore issues session tokens of the form id.user.expiry.signature. The signature is
+// an HMAC keyed by AUTH_SECRET, over the rest of the token and the user's password hash, so
+// changing the secret ends every session and resetting or removing a user ends theirs, without
+// having to track them, and the cookie on its own can't be used to log in. Sessions that are
+// logged out of are revoked by ID.
+type sessionStore struct {
+	key   []byte
+	path  string
+	users *userStore
+	now   func() time.Time
+	// origin is where the creator is served from, if that isn't the Host requests arrive
+	// with, as when a reverse proxy rewrites it. Requests from there are same origin too.
+	origin *url.URL
+
+	mu      sync.Mutex
+	revoked map[string]int64 // session ID -> expiry, in Unix seconds
+}
+
+// session is a logged in user's session.
+type session struct {
+	id   string
+	user string
+}
+
+func newSessionStore(secret, path string, users *userStore) (*sessionStore, error) {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("sonostalgia creator sessions"))
+	s := &sessionStore{key: mac.Sum(nil), path: path, users: users, now: time.Now, revoked: map[string]int64{}}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.revoked); err != nil {
+		return nil, fmt.Errorf("reading revoked sessions from %s: %w", path, err)
+	}
+	return s, nil
+}
+
+func (s *sessionStore) sign(payload string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// issue starts a new session for user, returning the cookie that holds its token.
+func (s *sessionStore) issue(user string) (*http.Cookie, error) {
+	hash, err := s.users.hash(user)
+	if err != nil {
+		return nil, err
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(id) + "." + user + "." + strconv.FormatInt(s.now().Add(sessionLifetime).Unix(), 10)
+	return &http.Cookie{
+		Name:     cookieName,
+		Value:    payload + "." + s.sign(payload+"."+hash),
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+		MaxAge:   int(sessionLifetime.Seconds()),
+	}, nil
+}
+
+// parse checks token's signature and expiry, returning its session and expiry if it's still good.
+func (s *sessionStore) parse(token string) (session, int64, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 4 {
+		return session{}, 0, false
+	}
+	id, user, expiry, signature := parts[0], parts[1], parts[2], parts[3]
+	hash, err := s.users.hash(user)
+	if err != nil {
+		log.Printf("checking session: %v", err)
+		return session{}, 0, false
+	}
+	if hash == "" || !hmac.Equal([]byte(signature), []byte(s.sign(id+"."+user+"."+expiry+"."+hash))) {
+		return session{}, 0, false
+	}
+	expires, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil || s.now().Unix() >= expires {
+		return session{}, 0, false
+	}
+	return session{id: id, user: user}, expires, true
+}
+
+// valid returns token's session, if it's one that's still going.
+func (s *sessionStore) valid(token string) (session, bool) {
+	sess, _, ok := s.parse(token)
+	if !ok {
+		return session{}, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, revoked := s.revoked[sess.id]; revoked {
+		return session{}, false
+	}
+	return sess, true
+}
+
+// csrfToken is the token pages must send back in the X-CSRF-Token header to change
+// anything. It's tied to the session, so it needs no storing and ends along with it.
+func (s *sessionStore) csrfToken(sessionID string) string {
+	return s.sign("csrf." + sessionID)
+}
+
+// revoke ends the session token belongs to. Tokens that aren't valid anyway are ignored.
+func (s *sessionStore) revoke(token string) error {
+	sess, expires, ok := s.parse(token)
+	if !ok {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now().Unix()
+	for revokedID, revokedExpiry := range s.revoked {
+		if revokedExpiry <= now {
+			delete(s.revoked, revokedID)
+		}
+	}
+	s.revoked[sess.id] = expires
+
+	data, err := json.Marshal(s.revoked)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, data, 0600)
+}
+
+type sessionKey struct{}
+
+// currentSession returns the session authMiddleware let r through with.
+func currentSession(r *http.Request) session {
+	sess, _ := r.Context().Value(sessionKey{}).(session)
+	return sess
+}
 
-func authMiddleware(secret string, next http.Handler) http.Handler {
+// authMiddleware lets through requests from a logged in session. Requests that could change
+// something must also come from the creator's own pages: they need the session's CSRF token,
+// and mustn't have come from another origin.
+func authMiddleware(sessions *sessionStore, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		cookie, err := r.Cookie(cookieName)
-		if err != nil || !hmac.Equal([]byte(cookie.Value), []byte(secret)) {
+		var sess session
+		var ok bool
+		if err == nil {
+			sess, ok = sessions.valid(cookie.Value)
+		}
+		if !ok {
 			if strings.HasPrefix(r.URL.Path, "/api/") {
 				http.Error(w, "unauthorized", http.StatusUnauthorized)
 			} else {
@@ -19,10 +189,52 @@ func authMiddleware(secret string, next http.Handler) http.Handler {
 			}
 			return
 		}
-		next.ServeHTTP(w, r)
+		if !safeMethod(r.Method) {
+			if !sessions.sameOrigin(r) {
+				http.Error(w, "cross-origin request refused", http.StatusForbidden)
+				return
+			}
+			if !hmac.Equal([]byte(r.Header.Get(csrfHeader)), []byte(sessions.csrfToken(sess.id))) {
+				http.Error(w, "missing or invalid CSRF token", http.StatusForbidden)
+				return
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), sessionKey{}, sess)))
 	})
 }
 
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// sameOrigin reports whether r was sent from one of the creator's own pages, going by its
+// Origin header, or its Referer if there isn't one. Browsers send at least one of them with
+// anything but a GET, so requests without either aren't from a browser, and are left to the
+// CSRF token.
+func (s *sessionStore) sameOrigin(r *http.Request) bool {
+	from := r.Header.Get("Origin")
+	if from == "" {
+		from = r.Header.Get("Referer")
+		if from == "" {
+			return true
+		}
+	}
+	u, err := url.Parse(from)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	return u.Host == r.Host || s.origin != nil && u.Scheme == s.origin.Scheme && u.Host == s.origin.Host
+}
+
+// parseOrigin reads an origin like https://creator.example.com, as CREATOR_ORIGIN is set to.
+func parseOrigin(origin string) (*url.URL, error) {
+	u, err := url.Parse(origin)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || strings.Trim(u.Path, "/") != "" {
+		return nil, fmt.Errorf("CREATOR_ORIGIN must be a scheme and host like https://creator.example.com, not %q", origin)
+	}
+	return u, nil
+}
+
 func handleLoginPage(loginHTML []byte) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
@@ -30,25 +242,78 @@ func handleLoginPage(loginHTML []byte) http.HandlerFunc {
 	}
 }
 
-func makeLoginHandler(secret string) http.HandlerFunc {
+func makeLoginHandler(users *userStore, sessions *sessionStore, limiter *loginLimiter) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
-		if !hmac.Equal([]byte(r.FormValue("secret")), []byte(secret)) {
+		if !sessions.sameOrigin(r) {
+			http.Error(w, "cross-origin request refused", http.StatusForbidden)
+			return
+		}
+		ip := limiter.clientIP(r)
+		if wait := limiter.wait(ip); wait > 0 {
+			redirectToWait(w, r, wait)
+			return
+		}
+		name := strings.TrimSpace(r.FormValue("user"))
+		ok, err := users.authenticate(name, r.FormValue("password"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			if wait := limiter.fail(ip, name); wait > 0 {
+				redirectToWait(w, r, wait)
+				return
+			}
 			http.Redirect(w, r, "/login?err=1", http.StatusFound)
 			return
 		}
+		limiter.succeed(ip, name)
+		cookie, err := sessions.issue(name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.SetCookie(w, cookie)
+		http.Redirect(w, r, "/", http.StatusFound)
+	}
+}
+
+// redirectToWait sends the browser back to the login page to be told how long to wait,
+// with a Retry-After header for anything that isn't a browser.
+func redirectToWait(w http.ResponseWriter, r *http.Request, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	http.Redirect(w, r, "/login?wait="+strconv.Itoa(seconds), http.StatusFound)
+}
+
+func makeLogoutHandler(sessions *sessionStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !sessions.sameOrigin(r) {
+			http.Error(w, "cross-origin request refused", http.StatusForbidden)
+			return
+		}
+		if cookie, err := r.Cookie(cookieName); err == nil {
+			if err := sessions.revoke(cookie.Value); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
 		http.SetCookie(w, &http.Cookie{
 			Name:     cookieName,
-			Value:    secret,
 			Path:     "/",
 			HttpOnly: true,
 			Secure:   true,
 			SameSite: http.SameSiteStrictMode,
-			MaxAge:   60 * 60 * 24 * 365,
+			MaxAge:   -1,
 		})
-		http.Redirect(w, r, "/", http.StatusFound)
+		http.Redirect(w, r, "/login", http.StatusFound)
 	}
 }
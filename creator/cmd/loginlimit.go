@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultFreeAttempts = 3
+	defaultBackoff      = time.Second
+	defaultLockoutAfter = 10
+	defaultLockout      = 15 * time.Minute
+)
+
+type loginLimitConfig struct {
+	FreeAttempts int           // failed logins allowed from an IP before it has to wait, 3 if zero
+	Backoff      time.Duration // the first wait, doubling with each failure after that, a second if zero
+	LockoutAfter int           // failed logins after which an IP is locked out, 10 if zero
+	Lockout      time.Duration // how long a lockout lasts, 15 minutes if zero
+	// TrustedProxies are the addresses of reverse proxies whose X-Forwarded-For is believed.
+	// Requests from anywhere else are limited by the address they came from.
+	TrustedProxies []netip.Prefix
+}
+
+// loginLimitConfigFromEnv reads LOGIN_FREE_ATTEMPTS, LOGIN_BACKOFF, LOGIN_LOCKOUT_AFTER,
+// LOGIN_LOCKOUT and TRUSTED_PROXIES, a comma separated list of IPs or CIDR ranges.
+func loginLimitConfigFromEnv() (loginLimitConfig, error) {
+	var config loginLimitConfig
+	for name, n := range map[string]*int{"LOGIN_FREE_ATTEMPTS": &config.FreeAttempts, "LOGIN_LOCKOUT_AFTER": &config.LockoutAfter} {
+		if value := os.Getenv(name); value != "" {
+			var err error
+			if *n, err = strconv.Atoi(value); err != nil || *n < 1 {
+				return config, fmt.Errorf("%s must be a positive number, not %q", name, value)
+			}
+		}
+	}
+	for name, d := range map[string]*time.Duration{"LOGIN_BACKOFF": &config.Backoff, "LOGIN_LOCKOUT": &config.Lockout} {
+		if value := os.Getenv(name); value != "" {
+			var err error
+			if *d, err = time.ParseDuration(value); err != nil || *d <= 0 {
+				return config, fmt.Errorf("%s must be a duration like 30s or 15m, not %q", name, value)
+			}
+		}
+	}
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(proxy)
+			if addrErr != nil {
+				return config, fmt.Errorf("TRUSTED_PROXIES: %q isn't an IP address or CIDR range", proxy)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		config.TrustedProxies = append(config.TrustedProxies, prefix.Masked())
+	}
+	return config, nil
+}
+
+// loginLimiter slows down guessing passwords. Each IP gets a few free attempts, after which
+// it has to wait before trying again, twice as long after each failure, until it's locked
+// out altogether for a while. Logging in successfully forgets the IP's failures as that user,
+// but not as anyone else, so that one account can't be used to keep guessing at others.
+type loginLimiter struct {
+	config loginLimitConfig
+	now    func() time.Time
+
+	mu       sync.Mutex
+	failures map[netip.Addr]*loginFailures
+}
+
+type loginFailures struct {
+	count  int
+	byUser map[string]int // how many of count were logging in as each user
+	last   time.Time
+	until  time.Time // no attempts are allowed before this
+}
+
+func newLoginLimiter(config loginLimitConfig) *loginLimiter {
+	if config.FreeAttempts == 0 {
+		config.FreeAttempts = defaultFreeAttempts
+	}
+	if config.Backoff == 0 {
+		config.Backoff = defaultBackoff
+	}
+	if config.LockoutAfter == 0 {
+		config.LockoutAfter = defaultLockoutAfter
+	}
+	if config.Lockout == 0 {
+		config.Lockout = defaultLockout
+	}
+	return &loginLimiter{config: config, now: time.Now, failures: map[netip.Addr]*loginFailures{}}
+}
+
+// wait returns how long ip has to wait before it may try to log in, or zero if it may now.
+func (l *loginLimiter) wait(ip netip.Addr) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f, ok := l.failures[ip]
+	if !ok {
+		return 0
+	}
+	return max(f.until.Sub(l.now()), 0)
+}
+
+// fail records a failed login from ip as user, logging it, and returns how long ip must now wait.
+func (l *loginLimiter) fail(ip netip.Addr, user string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.forgetOld(now)
+
+	f, ok := l.failures[ip]
+	if !ok {
+		f = &loginFailures{byUser: map[string]int{}}
+		l.failures[ip] = f
+	}
+	f.count++
+	f.byUser[user]++
+	f.last = now
+
+	var wait time.Duration
+	switch {
+	case f.count >= l.config.LockoutAfter:
+		wait = l.config.Lockout
+		slog.Warn("login locked out", "ip", ip, "user", user, "failures", f.count, "for", wait)
+	case f.count >= l.config.FreeAttempts:
+		wait = l.config.Backoff
+		for range f.count - l.config.FreeAttempts {
+			wait = min(wait*2, l.config.Lockout)
+		}
+		slog.Warn("failed login", "ip", ip, "user", user, "failures", f.count, "wait", wait)
+	default:
+		slog.Warn("failed login", "ip", ip, "user", user, "failures", f.count)
+	}
+	f.until = now.Add(wait)
+	return wait
+}
+
+// succeed records that ip logged in as user, forgetting its failures to do so.
+func (l *loginLimiter) succeed(ip netip.Addr, user string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f, ok := l.failures[ip]
+	if !ok {
+		return
+	}
+	f.count -= f.byUser[user]
+	delete(f.byUser, user)
+	if f.count == 0 {
+		delete(l.failures, ip)
+	}
+}
+
+// forgetOld drops IPs that haven't failed to log in for a lockout's length, so that the
+// occasional typo doesn't count against anyone for ever and the map doesn't grow without end.
+func (l *loginLimiter) forgetOld(now time.Time) {
+	for ip, f := range l.failures {
+		if now.Sub(f.last) > l.config.Lockout && !now.Before(f.until) {
+			delete(l.failures, ip)
+		}
+	}
+}
+
+// clientIP returns the address r came from. Behind trusted proxies, that's the last address
+// in X-Forwarded-For that isn't one of them: earlier entries are whatever the client sent,
+// and could be anything.
+func (l *loginLimiter) clientIP(r *http.Request) netip.Addr {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}
+	}
+	ip = ip.Unmap()
+	if !l.trusted(ip) {
+		return ip
+	}
+
+	var forwarded []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		forwarded = append(forwarded, strings.Split(header, ",")...)
+	}
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(forwarded[i]))
+		if err != nil {
+			// Past this point the header can't be trusted, so stop at the last good address.
+			break
+		}
+		ip = hop.Unmap()
+		if !l.trusted(ip) {
+			break
+		}
+	}
+	return ip
+}
+
+func (l *loginLimiter) trusted(ip netip.Addr) bool {
+	for _, proxy := range l.config.TrustedProxies {
+		if proxy.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
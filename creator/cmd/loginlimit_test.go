@@ -0,0 +1,160 @@
+package main
+
+import (
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestLoginIsRateLimited(t *testing.T) {
+	c := newCreator(t)
+	now := time.Now()
+	c.limiter.now = func() time.Time { return now }
+
+	login := func(password string) *http.Response {
+		t.Helper()
+		resp, err := c.client.PostForm(c.url+"/api/login", url.Values{"user": {testUser}, "password": {password}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	for range defaultFreeAttempts - 1 {
+		if resp := login("wrong"); resp.Header.Get("Location") != "/login?err=1" {
+			t.Fatalf("early wrong guess: redirected to %q, want /login?err=1", resp.Header.Get("Location"))
+		}
+	}
+	resp := login("wrong")
+	if resp.Header.Get("Location") != "/login?wait=1" || resp.Header.Get("Retry-After") != "1" {
+		t.Errorf("wrong guess after the free ones: redirected to %q with Retry-After %q, want a second's wait",
+			resp.Header.Get("Location"), resp.Header.Get("Retry-After"))
+	}
+	// Even the right password is refused until the wait is over.
+	if resp := login(testPassword); resp.Header.Get("Location") != "/login?wait=1" {
+		t.Errorf("logging in while waiting: redirected to %q, want /login?wait=1", resp.Header.Get("Location"))
+	}
+
+	now = now.Add(time.Second)
+	if resp := login("wrong"); resp.Header.Get("Location") != "/login?wait=2" {
+		t.Errorf("next wrong guess: redirected to %q, want the wait to double", resp.Header.Get("Location"))
+	}
+	now = now.Add(2 * time.Second)
+	c.login()
+
+	// Logging in starts afresh.
+	if resp := login("wrong"); resp.Header.Get("Location") != "/login?err=1" {
+		t.Errorf("wrong guess after logging in: redirected to %q, want /login?err=1", resp.Header.Get("Location"))
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	l := newLoginLimiter(loginLimitConfig{FreeAttempts: 2, Backoff: time.Second, LockoutAfter: 5, Lockout: time.Hour})
+	now := time.Now()
+	l.now = func() time.Time { return now }
+	ip := netip.MustParseAddr("192.0.2.1")
+
+	var waits []time.Duration
+	for range 5 {
+		wait := l.fail(ip, "someone")
+		waits = append(waits, wait)
+		now = now.Add(wait)
+	}
+	if want := []time.Duration{0, time.Second, 2 * time.Second, 4 * time.Second, time.Hour}; !slices.Equal(waits, want) {
+		t.Errorf("waits = %v, want %v", waits, want)
+	}
+	if wait := l.wait(netip.MustParseAddr("192.0.2.2")); wait != 0 {
+		t.Errorf("another IP has to wait %v", wait)
+	}
+
+	// Once a lockout has passed without another try, the failures are forgotten.
+	now = now.Add(time.Hour + time.Second)
+	l.fail(netip.MustParseAddr("192.0.2.2"), "someone")
+	if wait := l.fail(ip, "someone"); wait != 0 {
+		t.Errorf("first failure after a quiet hour: wait %v, want none", wait)
+	}
+}
+
+func TestLoginSuccessOnlyForgetsThatUser(t *testing.T) {
+	l := newLoginLimiter(loginLimitConfig{FreeAttempts: 2, Backoff: time.Second, LockoutAfter: 4, Lockout: time.Hour})
+	now := time.Now()
+	l.now = func() time.Time { return now }
+	ip := netip.MustParseAddr("192.0.2.1")
+
+	// Logging in to one account in between guesses at another doesn't hold off the lockout.
+	var wait time.Duration
+	for range 4 {
+		wait = l.fail(ip, "someone-else")
+		now = now.Add(wait)
+		l.succeed(ip, "me")
+	}
+	if wait != time.Hour {
+		t.Errorf("wait after guessing between logins = %v, want the lockout", wait)
+	}
+
+	// Getting your own password wrong and then right does.
+	other := netip.MustParseAddr("192.0.2.2")
+	for range 3 {
+		l.fail(other, "me")
+	}
+	l.succeed(other, "me")
+	if wait := l.fail(other, "me"); wait != 0 {
+		t.Errorf("wait after logging in = %v, want a fresh start", wait)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	l := newLoginLimiter(loginLimitConfig{TrustedProxies: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}})
+	tests := []struct {
+		name, remote, forwarded, want string
+	}{
+		{"direct", "192.0.2.1:5000", "", "192.0.2.1"},
+		{"untrusted proxy", "192.0.2.1:5000", "198.51.100.7", "192.0.2.1"},
+		{"trusted proxy", "10.0.0.1:5000", "198.51.100.7", "198.51.100.7"},
+		{"spoofed entries before the proxy", "10.0.0.1:5000", "203.0.113.9, 198.51.100.7", "198.51.100.7"},
+		{"chain of trusted proxies", "10.0.0.1:5000", "198.51.100.7, 10.0.0.2", "198.51.100.7"},
+		{"garbage before the client", "10.0.0.1:5000", "not-an-ip, 198.51.100.7", "198.51.100.7"},
+		{"garbage from the client", "10.0.0.1:5000", "not-an-ip", "10.0.0.1"},
+		{"IPv6", "[2001:db8::1]:5000", "", "2001:db8::1"},
+	}
+	for _, test := range tests {
+		r, err := http.NewRequest(http.MethodPost, "/api/login", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.RemoteAddr = test.remote
+		if test.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", test.forwarded)
+		}
+		if got := l.clientIP(r); got.String() != test.want {
+			t.Errorf("%s: clientIP = %s, want %s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestLoginLimitConfigFromEnv(t *testing.T) {
+	t.Setenv("LOGIN_FREE_ATTEMPTS", "5")
+	t.Setenv("LOGIN_LOCKOUT", "1h")
+	t.Setenv("TRUSTED_PROXIES", "127.0.0.1, 10.0.0.0/8")
+	config, err := loginLimitConfigFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []netip.Prefix{netip.MustParsePrefix("127.0.0.1/32"), netip.MustParsePrefix("10.0.0.0/8")}
+	if config.FreeAttempts != 5 || config.Lockout != time.Hour || config.Backoff != 0 || !slices.Equal(config.TrustedProxies, want) {
+		t.Errorf("config = %+v", config)
+	}
+
+	for name, value := range map[string]string{"LOGIN_BACKOFF": "soon", "LOGIN_LOCKOUT_AFTER": "0", "TRUSTED_PROXIES": "proxy.internal"} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(name, value)
+			if _, err := loginLimitConfigFromEnv(); err == nil {
+				t.Errorf("%s=%s was accepted", name, value)
+			}
+		})
+	}
+}
@@ -1,11 +1,14 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	_ "embed"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"io"
+	"html"
 	"log"
 	"net/http"
 	"os"
@@ -13,15 +16,14 @@ import (
 	"regexp"
 	"sort"
 	"strings"
+	"sync"
 	"time"
-	"unicode"
 
+	"github.com/alexflint/go-arg"
 	sonostalgia "github.com/azoghal/sonostalgia/src"
+	"github.com/azoghal/sonostalgia/src/metadata"
 	"github.com/azoghal/sonostalgia/src/templater"
 	"github.com/joho/godotenv"
-	spotify "github.com/zmb3/spotify/v2"
-	spotifyauth "github.com/zmb3/spotify/v2/auth"
-	"golang.org/x/oauth2/clientcredentials"
 	"gopkg.in/yaml.v3"
 )
 
@@ -32,24 +34,24 @@ var indexHTML []byte
 var loginHTML []byte
 
 const (
-	minDesiredWidth = 100
-	maxDesiredWidth = 350
-	wipsPath        = "src/wip-memories/ideas.yaml"
+	wipsPath = "src/wip-memories/ideas.yaml"
 )
 
 var (
-	spotifyURLRe  = regexp.MustCompile(`open\.spotify\.com/track/([A-Za-z0-9]+)`)
-	spotifyURIRe  = regexp.MustCompile(`^spotify:track:([A-Za-z0-9]+)$`)
-	validSlugRe   = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*[a-z0-9]$`)
+	validSlugRe = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*[a-z0-9]$`)
 )
 
 type server struct {
-	client *spotify.Client
-	ctx    context.Context
+	providers map[string]metadata.MetadataProvider // by name, e.g. metadata.SpotifyProvider
+	ctx       context.Context
+
+	saveMu  sync.Mutex // one save at a time, so that each is checked against the version before it
+	buildMu sync.Mutex // one rebuild at a time, so that saves in quick succession publish in order
 }
 
 type SearchRequest struct {
-	Query string `json:"query"`
+	Query    string `json:"query"`
+	Provider string `json:"provider"` // defaults to spotify
 }
 
 // SongResult is returned by both /api/search and /api/fetch-song.
@@ -64,7 +66,8 @@ type SongResult struct {
 }
 
 type FetchRequest struct {
-	URL string `json:"url"`
+	URL      string `json:"url"`
+	Provider string `json:"provider"` // defaults to spotify
 }
 
 type SaveSong struct {
@@ -84,14 +87,27 @@ type MemoryListItem struct {
 
 // MemoryResponse is used for /api/memory — gives the frontend predictable camelCase keys.
 type MemoryResponse struct {
-	OutputTitle string         `json:"outputTitle"`
-	ShortTitle  string         `json:"shortTitle"`
-	Title       string         `json:"title"`
-	Subtitle    string         `json:"subtitle"`
-	Date        string         `json:"date"`
-	Content     string         `json:"content"`
-	Songs       []SongResponse `json:"songs"`
-	OtherSongs  []SongResponse `json:"otherSongs"`
+	OutputTitle string              `json:"outputTitle"`
+	ShortTitle  string              `json:"shortTitle"`
+	Title       string              `json:"title"`
+	Subtitle    string              `json:"subtitle"`
+	Date        string              `json:"date"`
+	Content     string              `json:"content"`
+	Songs       []SongResponse      `json:"songs"`
+	OtherSongs  []SongResponse      `json:"otherSongs"`
+	Tags        []string            `json:"tags"`
+	People      []string            `json:"people"`
+	Places      []sonostalgia.Place `json:"places"`
+	Author      string              `json:"author,omitempty"`
+	EditedBy    string              `json:"editedBy,omitempty"`
+	Version     string              `json:"version"` // also sent as the ETag
+}
+
+// ConflictResponse is sent with a 409 when a save would overwrite changes made since the
+// memory was loaded, with the memory as it now is so that the two can be merged.
+type ConflictResponse struct {
+	Error   string          `json:"error"`
+	Current *MemoryResponse `json:"current"` // nil if the memory has been deleted
 }
 
 type SongResponse struct {
@@ -112,6 +128,7 @@ type WIPEntry struct {
 	Title   string `yaml:"title"   json:"title"`
 	Notes   string `yaml:"notes"   json:"notes"`
 	Created string `yaml:"created" json:"created"`
+	Author  string `yaml:"author,omitempty" json:"author,omitempty"`
 }
 
 type AddWIPRequest struct {
@@ -120,18 +137,54 @@ type AddWIPRequest struct {
 }
 
 type SaveRequest struct {
-	OutputTitle string     `json:"outputTitle"`
-	Title       string     `json:"title"`
-	ShortTitle  string     `json:"shortTitle"`
-	Subtitle    string     `json:"subtitle"`
-	Date        string     `json:"date"`
-	Content     string     `json:"content"`
-	Songs       []SaveSong `json:"songs"`
-	OtherSongs  []SaveSong `json:"otherSongs"`
-	Rebuild     bool       `json:"rebuild"`
+	OutputTitle string              `json:"outputTitle"`
+	Title       string              `json:"title"`
+	ShortTitle  string              `json:"shortTitle"`
+	Subtitle    string              `json:"subtitle"`
+	Date        string              `json:"date"`
+	Content     string              `json:"content"`
+	Songs       []SaveSong          `json:"songs"`
+	OtherSongs  []SaveSong          `json:"otherSongs"`
+	Tags        []string            `json:"tags"`
+	People      []string            `json:"people"`
+	Places      []sonostalgia.Place `json:"places"`
+	Rebuild     bool                `json:"rebuild"`
+}
+
+type Args struct {
+	Users *UsersCmd `arg:"subcommand:users" help:"add, remove or reset the users who can log in, instead of serving"`
+}
+
+type UsersCmd struct {
+	Add    *AddUserCmd    `arg:"subcommand:add"    help:"add a user"`
+	Remove *RemoveUserCmd `arg:"subcommand:remove" help:"remove a user, ending their sessions"`
+	Reset  *ResetUserCmd  `arg:"subcommand:reset"  help:"give a user a new password, ending their sessions"`
+	List   *ListUsersCmd  `arg:"subcommand:list"   help:"list the users"`
+}
+
+type AddUserCmd struct {
+	Name          string `arg:"positional,required"`
+	PasswordStdin bool   `arg:"--password-stdin" help:"read the password from standard input instead of making one up"`
 }
 
+type ResetUserCmd AddUserCmd
+
+type RemoveUserCmd struct {
+	Name string `arg:"positional,required"`
+}
+
+type ListUsersCmd struct{}
+
 func main() {
+	var args Args
+	p := arg.MustParse(&args)
+	if args.Users != nil {
+		if err := runUsers(p, usersPath); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	if err := godotenv.Load(); err != nil {
 		log.Fatal("failed to load .env")
 	}
@@ -140,24 +193,56 @@ func main() {
 	if secret == "" {
 		log.Fatal("AUTH_SECRET must be set in .env")
 	}
-
-	ctx := context.Background()
-	config := &clientcredentials.Config{
-		ClientID:     os.Getenv("SPOTIFY_CLIENT_ID"),
-		ClientSecret: os.Getenv("SPOTIFY_CLIENT_SECRET"),
-		TokenURL:     spotifyauth.TokenURL,
+	users := &userStore{path: usersPath}
+	if list, err := loadUsers(usersPath); err != nil {
+		log.Fatal(err)
+	} else if len(list) == 0 {
+		log.Fatalf("nobody can log in: add a user with `creator users add NAME`, which keeps them in %s", usersPath)
+	}
+	sessions, err := newSessionStore(secret, revokedSessionsPath, users)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if origin := os.Getenv("CREATOR_ORIGIN"); origin != "" {
+		if sessions.origin, err = parseOrigin(origin); err != nil {
+			log.Fatal(err)
+		}
+	}
+	loginLimits, err := loginLimitConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
 	}
 
+	ctx := context.Background()
 	s := &server{
-		client: spotify.New(config.Client(ctx)),
-		ctx:    ctx,
+		providers: map[string]metadata.MetadataProvider{
+			metadata.SpotifyProvider: metadata.NewSpotify(ctx, metadata.SpotifyConfig{
+				ClientID:     os.Getenv("SPOTIFY_CLIENT_ID"),
+				ClientSecret: os.Getenv("SPOTIFY_CLIENT_SECRET"),
+				Market:       os.Getenv("SPOTIFY_MARKET"),
+				BaseURL:      os.Getenv("SPOTIFY_API_URL"),
+			}),
+			metadata.MusicBrainzProvider: metadata.NewMusicBrainz(metadata.MusicBrainzConfig{
+				BaseURL:     os.Getenv("MUSICBRAINZ_API_URL"),
+				CoverArtURL: os.Getenv("COVERART_API_URL"),
+			}),
+		},
+		ctx: ctx,
 	}
 
+	addr := ":8765"
+	fmt.Printf("Sonostalgia Creator → http://localhost%s\n", addr)
+	log.Fatal(http.ListenAndServe(addr, s.routes(users, sessions, newLoginLimiter(loginLimits))))
+}
+
+func (s *server) routes(users *userStore, sessions *sessionStore, limiter *loginLimiter) http.Handler {
 	// Authenticated routes — all behind the cookie check.
 	authed := http.NewServeMux()
 	authed.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		w.Write(indexHTML)
+		sess := currentSession(r)
+		page := bytes.Replace(indexHTML, []byte("{{csrfToken}}"), []byte(html.EscapeString(sessions.csrfToken(sess.id))), 1)
+		w.Write(bytes.Replace(page, []byte("{{user}}"), []byte(html.EscapeString(sess.user)), 1))
 	})
 	authed.Handle("/assets/", http.StripPrefix("/assets/", http.FileServer(http.Dir("src/assets"))))
 	authed.HandleFunc("/api/memories", s.handleListMemories)
@@ -171,12 +256,10 @@ func main() {
 	// Top-level mux: login routes are public, everything else is protected.
 	mux := http.NewServeMux()
 	mux.HandleFunc("/login", handleLoginPage(loginHTML))
-	mux.HandleFunc("/api/login", makeLoginHandler(secret))
-	mux.Handle("/", authMiddleware(secret, authed))
-
-	addr := ":8765"
-	fmt.Printf("Sonostalgia Creator → http://localhost%s\n", addr)
-	log.Fatal(http.ListenAndServe(addr, mux))
+	mux.HandleFunc("/api/login", makeLoginHandler(users, sessions, limiter))
+	mux.HandleFunc("/api/logout", makeLogoutHandler(sessions))
+	mux.Handle("/", authMiddleware(sessions, authed))
+	return mux
 }
 
 func loadWIPs() ([]WIPEntry, error) {
@@ -239,6 +322,7 @@ func (s *server) handleWIPs(w http.ResponseWriter, r *http.Request) {
 			Title:   strings.TrimSpace(req.Title),
 			Notes:   strings.TrimSpace(req.Notes),
 			Created: time.Now().Format("2006-01-02"),
+			Author:  currentSession(r).user,
 		}
 		entries = append(entries, entry)
 		if err := saveWIPs(entries); err != nil {
@@ -278,6 +362,7 @@ func (s *server) handleDeleteWIP(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	log.Printf("%s deleted idea %s", currentSession(r).user, id)
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -310,17 +395,72 @@ func (s *server) handleGetMemory(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	mem, err := sonostalgia.LoadMemory(fmt.Sprintf("src/memories/%s.yaml", slug))
+	mem, version, err := loadMemoryVersion(fmt.Sprintf("src/memories/%s.yaml", slug))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(memoryToResponse(mem))
+	w.Header().Set("ETag", version)
+	json.NewEncoder(w).Encode(memoryToResponse(mem, version))
+}
+
+// loadMemoryVersion loads a memory along with its version, an ETag made from the file's
+// contents, which saves must send back to show they're editing the copy on disk.
+func loadMemoryVersion(path string) (*sonostalgia.Memory, string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	mem, err := sonostalgia.ParseMemory(data)
+	if err != nil {
+		return nil, "", err
+	}
+	return mem, memoryVersion(data), nil
 }
 
-func memoryToResponse(mem *sonostalgia.Memory) MemoryResponse {
+func memoryVersion(data []byte) string {
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches reports whether an If-Match or If-None-Match header matches version, the
+// current version of a memory or "" if there isn't one.
+func etagMatches(header, version string) bool {
+	if version == "" {
+		return false
+	}
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	for _, tag := range strings.Split(header, ",") {
+		if strings.TrimSpace(tag) == version {
+			return true
+		}
+	}
+	return false
+}
+
+// checkVersion returns the status and reason to refuse a save with, given the version of the
+// memory on disk, or "" if there isn't one, or 0 if the save can go ahead. Saves must send
+// If-Match with the version they loaded, or If-None-Match: * when making a new memory.
+func checkVersion(r *http.Request, version string) (int, string) {
+	ifMatch, ifNoneMatch := r.Header.Get("If-Match"), r.Header.Get("If-None-Match")
+	switch {
+	case ifMatch == "" && ifNoneMatch == "":
+		return http.StatusPreconditionRequired, "saving needs If-Match with the version loaded, or If-None-Match: * for a new memory"
+	case ifNoneMatch != "" && etagMatches(ifNoneMatch, version):
+		return http.StatusConflict, "there's already a memory with this slug"
+	case ifMatch != "" && version == "":
+		return http.StatusConflict, "the memory has been deleted since it was loaded"
+	case ifMatch != "" && !etagMatches(ifMatch, version):
+		return http.StatusConflict, "the memory has been changed since it was loaded"
+	}
+	return 0, ""
+}
+
+func memoryToResponse(mem *sonostalgia.Memory, version string) MemoryResponse {
 	mapSongs := func(songs []sonostalgia.Song) []SongResponse {
 		out := make([]SongResponse, len(songs))
 		for i, s := range songs {
@@ -332,7 +472,7 @@ func memoryToResponse(mem *sonostalgia.Memory) MemoryResponse {
 				Name:         s.Name,
 				SongLink:     s.SongLink,
 				Artists:      artists,
-				RelevantDate: s.RelevantDate,
+				RelevantDate: s.RelevantDate.String(),
 				ImageLink:    s.ImageLink,
 			}
 		}
@@ -344,11 +484,25 @@ func memoryToResponse(mem *sonostalgia.Memory) MemoryResponse {
 		ShortTitle:  mem.PageTitle,
 		Title:       mem.Title,
 		Subtitle:    mem.Subtitle,
-		Date:        mem.Date,
+		Date:        mem.Date.String(),
 		Content:     mem.Content,
 		Songs:       mapSongs(mem.Songs),
 		OtherSongs:  mapSongs(mem.OtherSongs),
+		Tags:        nonNil(mem.Tags),
+		People:      nonNil(mem.People),
+		Places:      mem.Places,
+		Author:      mem.Author,
+		EditedBy:    mem.EditedBy,
+		Version:     version,
+	}
+}
+
+// nonNil makes sure empty lists are sent as [] rather than null.
+func nonNil(list []string) []string {
+	if list == nil {
+		return []string{}
 	}
+	return list
 }
 
 func (s *server) handleSearch(w http.ResponseWriter, r *http.Request) {
@@ -358,27 +512,21 @@ func (s *server) handleSearch(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	res, err := s.client.Search(s.ctx, req.Query, spotify.SearchTypeTrack, spotify.Limit(8), spotify.Market("GB"))
+	provider, ok := s.provider(req.Provider)
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown provider %q", req.Provider), http.StatusBadRequest)
+		return
+	}
+
+	tracks, err := provider.Search(s.ctx, req.Query, 8)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	out := make([]SongResult, 0, len(res.Tracks.Tracks))
-	for _, t := range res.Tracks.Tracks {
-		artists := make([]sonostalgia.Artist, len(t.Artists))
-		for i, a := range t.Artists {
-			artists[i] = sonostalgia.Artist{Name: a.Name, Link: a.ExternalURLs["spotify"]}
-		}
-		out = append(out, SongResult{
-			ID:        t.ID.String(),
-			Name:      t.Name,
-			SongLink:  t.ExternalURLs["spotify"],
-			Artists:   artists,
-			AlbumName: t.Album.Name,
-			ImageURL:  bestImageURL(t.Album.Images),
-			ImageName: makeImageName(t.Name),
-		})
+	out := make([]SongResult, 0, len(tracks))
+	for _, t := range tracks {
+		out = append(out, songResult(t))
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -392,34 +540,47 @@ func (s *server) handleFetchSong(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	id := extractTrackID(req.URL)
-	track, err := s.client.GetTrack(s.ctx, spotify.ID(id), spotify.Market("GB"))
-	if err != nil {
-		http.Error(w, fmt.Sprintf("track lookup failed: %v", err), http.StatusBadGateway)
+	provider, ok := s.provider(req.Provider)
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown provider %q", req.Provider), http.StatusBadRequest)
 		return
 	}
 
-	album, err := s.client.GetAlbum(s.ctx, track.Album.ID, spotify.Market("GB"))
+	track, err := provider.GetTrack(s.ctx, req.URL)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("album lookup failed: %v", err), http.StatusBadGateway)
+		http.Error(w, err.Error(), http.StatusBadGateway)
 		return
 	}
 
-	artists := make([]sonostalgia.Artist, len(track.Artists))
-	for i, a := range track.Artists {
-		artists[i] = sonostalgia.Artist{Name: a.Name, Link: a.ExternalURLs["spotify"]}
+	track.ImageURL, err = provider.GetAlbumArt(s.ctx, track.AlbumID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(SongResult{
-		ID:        track.ID.String(),
-		Name:      track.Name,
-		SongLink:  track.ExternalURLs["spotify"],
-		Artists:   artists,
-		AlbumName: album.Name,
-		ImageURL:  bestImageURL(album.Images),
-		ImageName: makeImageName(track.Name),
-	})
+	json.NewEncoder(w).Encode(songResult(*track))
+}
+
+// provider looks a provider up by name, defaulting to Spotify.
+func (s *server) provider(name string) (metadata.MetadataProvider, bool) {
+	if name == "" {
+		name = metadata.SpotifyProvider
+	}
+	p, ok := s.providers[name]
+	return p, ok
+}
+
+func songResult(t metadata.Track) SongResult {
+	return SongResult{
+		ID:        t.ID,
+		Name:      t.Name,
+		SongLink:  t.Link,
+		Artists:   t.Artists,
+		AlbumName: t.AlbumName,
+		ImageURL:  t.ImageURL,
+		ImageName: metadata.ImageName(t.Name),
+	}
 }
 
 func (s *server) handleSave(w http.ResponseWriter, r *http.Request) {
@@ -433,20 +594,82 @@ func (s *server) handleSave(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := os.MkdirAll("src/assets", 0755); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	date, err := sonostalgia.ParseDateSpec(req.Date)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	yamlPath, version, ok := s.writeMemory(w, r, req, date)
+	if !ok {
+		return
+	}
+
+	if req.Rebuild {
+		s.buildMu.Lock()
+		defer s.buildMu.Unlock()
+		if err := templater.Run("src", "output", templater.Options{SiteURL: os.Getenv("SITE_URL")}); err != nil {
+			http.Error(w, fmt.Sprintf("rebuild failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", version)
+	json.NewEncoder(w).Encode(map[string]string{"path": yamlPath, "version": version})
+}
+
+// writeMemory saves req as long as the memory hasn't changed since it was loaded, returning
+// where it went and its new version. Otherwise it responds with the conflict, or whatever
+// else went wrong, and returns false.
+func (s *server) writeMemory(w http.ResponseWriter, r *http.Request, req SaveRequest, date sonostalgia.DateSpec) (string, string, bool) {
+	// Checking the version and writing the new one happen together, or two saves of the same
+	// version could both be let through.
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+
+	yamlPath := fmt.Sprintf("src/memories/%s.yaml", req.OutputTitle)
+	existing, version, err := loadMemoryVersion(yamlPath)
+	if err != nil && !os.IsNotExist(err) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return "", "", false
+	}
+	if status, reason := checkVersion(r, version); status != 0 {
+		conflict := ConflictResponse{Error: reason}
+		if existing != nil {
+			current := memoryToResponse(existing, version)
+			conflict.Current = &current
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(conflict)
+		return "", "", false
+	}
+
 	songs, err := processSongs(req.Songs)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return "", "", false
 	}
 	otherSongs, err := processSongs(req.OtherSongs)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return "", "", false
+	}
+
+	// Keep the original publish time when editing, so feed readers don't see the memory as new,
+	// any palette set by hand, which the creator has no controls for, and who wrote it first.
+	published := time.Now().UTC().Truncate(time.Second)
+	user := currentSession(r).user
+	author, editedBy := user, ""
+	var palette *sonostalgia.Palette
+	if existing != nil {
+		if !existing.Published.IsZero() {
+			published = existing.Published
+		}
+		palette = existing.Palette
+		// Memories from before there were users have no author, and stay that way.
+		author, editedBy = existing.Author, user
 	}
 
 	mem := sonostalgia.Memory{
@@ -454,116 +677,111 @@ func (s *server) handleSave(w http.ResponseWriter, r *http.Request) {
 		PageTitle:   req.ShortTitle,
 		Title:       req.Title,
 		Subtitle:    req.Subtitle,
-		Date:        req.Date,
+		Date:        date,
+		Published:   published,
 		Content:     req.Content,
 		Songs:       songs,
 		OtherSongs:  otherSongs,
+		Tags:        cleanLabels(req.Tags),
+		People:      cleanLabels(req.People),
+		Places:      req.Places,
+		Palette:     palette,
+		Author:      author,
+		EditedBy:    editedBy,
 	}
 
+	// The memory is checked as it will be written, so that a bad save can't stop the site
+	// building. Covers are downloaded afterwards, so nothing is written if it's refused.
 	data, err := yaml.Marshal(mem)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return "", "", false
 	}
-
-	yamlPath := fmt.Sprintf("src/memories/%s.yaml", req.OutputTitle)
-	if err := os.WriteFile(yamlPath, data, 0644); err != nil {
+	memoryFiles, err := filepath.Glob("src/memories/*.yaml")
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return "", "", false
+	}
+	if problems := sonostalgia.ValidateMemory("src", yamlPath, data, memoryFiles); len(problems) > 0 {
+		lines := make([]string, len(problems))
+		for i, p := range problems {
+			lines[i] = p.Message
+		}
+		http.Error(w, "the memory has problems:\n"+strings.Join(lines, "\n"), http.StatusBadRequest)
+		return "", "", false
 	}
-	log.Printf("saved %s", yamlPath)
 
-	if req.Rebuild {
-		if err := templater.Run("src", "output"); err != nil {
-			http.Error(w, fmt.Sprintf("rebuild failed: %v", err), http.StatusInternalServerError)
-			return
+	if err := os.MkdirAll("src/assets", 0755); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return "", "", false
+	}
+	if downloadCovers(mem.Songs, req.Songs)+downloadCovers(mem.OtherSongs, req.OtherSongs) > 0 {
+		if data, err = yaml.Marshal(mem); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return "", "", false
 		}
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"path": yamlPath})
+	if err := os.WriteFile(yamlPath, data, 0644); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return "", "", false
+	}
+	log.Printf("%s saved %s", user, yamlPath)
+	return yamlPath, memoryVersion(data), true
 }
 
+// processSongs turns the songs in a save into the memory's songs, with the images they
+// already have; downloadCovers fetches any new ones.
 func processSongs(songs []SaveSong) ([]sonostalgia.Song, error) {
 	out := make([]sonostalgia.Song, 0, len(songs))
 	for _, s := range songs {
-		imageLink := s.ExistingImageLink
-		if s.SpotifyImageURL != "" && s.ImageName != "" {
-			dest := fmt.Sprintf("src/assets/%s.jpg", s.ImageName)
-			if err := downloadImage(s.SpotifyImageURL, dest); err != nil {
-				log.Printf("warning: failed to download image for %q: %v", s.Name, err)
-			} else {
-				imageLink = fmt.Sprintf("assets/%s.jpg", s.ImageName)
-			}
+		relevantDate, err := sonostalgia.ParseDateSpec(s.RelevantDate)
+		if err != nil {
+			return nil, fmt.Errorf("song %q: %w", s.Name, err)
 		}
 		out = append(out, sonostalgia.Song{
 			Name:         s.Name,
 			SongLink:     s.SongLink,
 			Artists:      s.Artists,
-			RelevantDate: s.RelevantDate,
-			ImageLink:    imageLink,
+			RelevantDate: relevantDate,
+			ImageLink:    s.ExistingImageLink,
 		})
 	}
 	return out, nil
 }
 
-func extractTrackID(s string) string {
-	if m := spotifyURLRe.FindStringSubmatch(s); m != nil {
-		return m[1]
-	}
-	if m := spotifyURIRe.FindStringSubmatch(s); m != nil {
-		return m[1]
-	}
-	return strings.TrimSpace(s)
-}
-
-func bestImageURL(images []spotify.Image) string {
-	var best *spotify.Image
-	bestScore := 0
-	for i, img := range images {
-		w := int(img.Width)
-		score := 1
-		if w < maxDesiredWidth {
-			score++
-		}
-		if w > minDesiredWidth {
-			score++
+// downloadCovers saves the covers of songs picked from a search into src/assets, pointing
+// the songs at them, and returns how many it got. A cover that fails to download leaves its
+// song with the image it had.
+func downloadCovers(songs []sonostalgia.Song, saved []SaveSong) int {
+	downloaded := 0
+	for i, s := range saved {
+		if s.SpotifyImageURL == "" || s.ImageName == "" {
+			continue
 		}
-		if score > bestScore {
-			best = &images[i]
-			bestScore = score
+		dest := fmt.Sprintf("src/assets/%s.jpg", s.ImageName)
+		if err := metadata.DownloadImage(s.SpotifyImageURL, dest); err != nil {
+			log.Printf("warning: failed to download image for %q: %v", s.Name, err)
+			continue
 		}
+		songs[i].ImageLink = fmt.Sprintf("assets/%s.jpg", s.ImageName)
+		downloaded++
 	}
-	if best == nil {
-		return ""
-	}
-	return best.URL
+	return downloaded
 }
 
-func makeImageName(trackName string) string {
-	alpha := strings.Map(func(r rune) rune {
-		if unicode.IsLetter(r) || unicode.IsNumber(r) || unicode.IsSpace(r) {
-			return r
+// cleanLabels trims tags or people and drops blanks and repeats.
+func cleanLabels(labels []string) []string {
+	var out []string
+	seen := map[string]bool{}
+	for _, label := range labels {
+		label = strings.TrimSpace(label)
+		key := strings.ToLower(label)
+		if label == "" || seen[key] {
+			continue
 		}
-		return -1
-	}, trackName)
-	return strings.Join(strings.Fields(strings.ToLower(alpha)), "-")
-}
-
-func downloadImage(url, filePath string) error {
-	resp, err := http.Get(url)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("bad status: %s", resp.Status)
-	}
-	f, err := os.Create(filePath)
-	if err != nil {
-		return err
+		seen[key] = true
+		out = append(out, label)
 	}
-	defer f.Close()
-	_, err = io.Copy(f, resp.Body)
-	return err
+	return out
 }
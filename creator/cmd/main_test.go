@@ -0,0 +1,864 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image/jpeg"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	sonostalgia "github.com/azoghal/sonostalgia/src"
+	"github.com/azoghal/sonostalgia/src/metadata"
+	"github.com/azoghal/sonostalgia/src/metadata/musicbrainzfake"
+	"github.com/azoghal/sonostalgia/src/metadata/spotifyfake"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	testSecret   = "test-secret" // AUTH_SECRET, which signs sessions
+	testUser     = "tester"
+	testPassword = "test-password"
+)
+
+// creator is a running creator, backed by the fake Spotify and MusicBrainz APIs and working in a temporary directory.
+type creator struct {
+	t           *testing.T
+	url         string
+	client      *http.Client
+	spotify     *spotifyfake.Server
+	musicBrainz *musicbrainzfake.Server
+	sessions    *sessionStore
+	limiter     *loginLimiter
+	csrfToken   string // from the page served after logging in
+}
+
+func newCreator(t *testing.T) *creator {
+	t.Helper()
+
+	// The handlers read and write relative to the repository root.
+	t.Chdir(t.TempDir())
+	for _, dir := range []string{"src/memories", "src/assets"} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fakeSpotify := spotifyfake.New()
+	spotifyAPI := httptest.NewServer(fakeSpotify)
+	t.Cleanup(spotifyAPI.Close)
+
+	fakeMusicBrainz := musicbrainzfake.New()
+	musicBrainzAPI := httptest.NewServer(fakeMusicBrainz)
+	t.Cleanup(musicBrainzAPI.Close)
+
+	ctx := context.Background()
+	s := &server{
+		providers: map[string]metadata.MetadataProvider{
+			metadata.SpotifyProvider: metadata.NewSpotify(ctx, metadata.SpotifyConfig{
+				ClientID:     "client-id",
+				ClientSecret: "client-secret",
+				BaseURL:      spotifyAPI.URL,
+			}),
+			metadata.MusicBrainzProvider: metadata.NewMusicBrainz(metadata.MusicBrainzConfig{
+				BaseURL:     musicBrainzAPI.URL,
+				CoverArtURL: musicBrainzAPI.URL,
+				Interval:    time.Nanosecond,
+			}),
+		},
+		ctx: ctx,
+	}
+
+	fastHashing(t)
+	if _, err := setPassword(usersPath, testUser, testPassword, false); err != nil {
+		t.Fatal(err)
+	}
+	users := &userStore{path: usersPath}
+	sessions, err := newSessionStore(testSecret, revokedSessionsPath, users)
+	if err != nil {
+		t.Fatal(err)
+	}
+	limiter := newLoginLimiter(loginLimitConfig{})
+	// The session cookie is Secure, so the creator has to be served over TLS for the jar to send it.
+	app := httptest.NewTLSServer(s.routes(users, sessions, limiter))
+	t.Cleanup(app.Close)
+
+	client := app.Client()
+	client.Jar, _ = cookiejar.New(nil)
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+
+	return &creator{t: t, url: app.URL, client: client, spotify: fakeSpotify, musicBrainz: fakeMusicBrainz, sessions: sessions, limiter: limiter}
+}
+
+func (c *creator) login() {
+	c.t.Helper()
+	c.loginAs(testUser, testPassword)
+}
+
+func (c *creator) loginAs(user, password string) {
+	c.t.Helper()
+	resp, err := c.client.PostForm(c.url+"/api/login", url.Values{"user": {user}, "password": {password}})
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound || resp.Header.Get("Location") != "/" {
+		c.t.Fatalf("login: got %s to %q, want a redirect to /", resp.Status, resp.Header.Get("Location"))
+	}
+
+	resp, err = c.client.Get(c.url + "/")
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	page, err := io.ReadAll(resp.Body)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	match := csrfMetaRe.FindSubmatch(page)
+	if match == nil {
+		c.t.Fatal("the page has no CSRF token")
+	}
+	c.csrfToken = string(match[1])
+}
+
+var csrfMetaRe = regexp.MustCompile(`<meta name="csrf-token" content="([^"]+)">`)
+
+// do sends body as JSON and decodes a JSON response into out, if given, returning the status code.
+func (c *creator) do(method, path string, body, out any) int {
+	c.t.Helper()
+	return c.doWith(method, path, nil, body, out)
+}
+
+// save saves a memory, as an edit of the given version, or as a new memory if version is "".
+// Conflicts are decoded into conflict, if given.
+func (c *creator) save(req SaveRequest, version string, conflict *ConflictResponse) int {
+	c.t.Helper()
+	header := http.Header{"If-None-Match": {"*"}}
+	if version != "" {
+		header = http.Header{"If-Match": {version}}
+	}
+	if conflict == nil {
+		return c.doWith(http.MethodPost, "/api/save", header, req, nil)
+	}
+	return c.doWith(http.MethodPost, "/api/save", header, req, conflict)
+}
+
+// doWith is do with extra request headers. Responses are decoded into out when they
+// succeed, or when they're conflicts, which carry the server's copy.
+func (c *creator) doWith(method, path string, header http.Header, body, out any) int {
+	c.t.Helper()
+	var reader *bytes.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	req, err := http.NewRequest(method, c.url+path, reader)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.csrfToken != "" {
+		req.Header.Set(csrfHeader, c.csrfToken)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if out != nil && (resp.StatusCode < 300 || resp.StatusCode == http.StatusConflict) {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			c.t.Fatalf("%s %s: decoding response: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestAPIRequiresLogin(t *testing.T) {
+	c := newCreator(t)
+
+	if status := c.do(http.MethodPost, "/api/search", SearchRequest{Query: "juno"}, nil); status != http.StatusUnauthorized {
+		t.Errorf("search without logging in: got %d, want %d", status, http.StatusUnauthorized)
+	}
+
+	resp, err := c.client.Get(c.url + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound || resp.Header.Get("Location") != "/login" {
+		t.Errorf("index without logging in: got %s to %q, want a redirect to /login", resp.Status, resp.Header.Get("Location"))
+	}
+
+	for _, login := range []url.Values{
+		{"user": {testUser}, "password": {"wrong"}},
+		{"user": {"nobody"}, "password": {testPassword}},
+	} {
+		resp, err = c.client.PostForm(c.url+"/api/login", login)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.Header.Get("Location") != "/login?err=1" {
+			t.Errorf("login as %s with password %s: redirected to %q, want /login?err=1", login.Get("user"), login.Get("password"), resp.Header.Get("Location"))
+		}
+	}
+}
+
+// sessionCookie returns the session cookie the client holds, or nil.
+func (c *creator) sessionCookie() *http.Cookie {
+	c.t.Helper()
+	u, err := url.Parse(c.url)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	for _, cookie := range c.client.Jar.Cookies(u) {
+		if cookie.Name == cookieName {
+			return cookie
+		}
+	}
+	return nil
+}
+
+func TestSessions(t *testing.T) {
+	c := newCreator(t)
+	c.login()
+	session := c.sessionCookie()
+	if session == nil || strings.Contains(session.Value, testSecret) || strings.Contains(session.Value, testPassword) {
+		t.Fatalf("session cookie = %v, want a token that doesn't give away the secret or password", session)
+	}
+	if status := c.do(http.MethodGet, "/api/memories", nil, nil); status != http.StatusOK {
+		t.Fatalf("listing memories after logging in: got %d", status)
+	}
+
+	resp, err := c.client.Post(c.url+"/api/logout", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound || resp.Header.Get("Location") != "/login" {
+		t.Errorf("logout: got %s to %q, want a redirect to /login", resp.Status, resp.Header.Get("Location"))
+	}
+	if c.sessionCookie() != nil {
+		t.Error("logging out didn't clear the session cookie")
+	}
+
+	// A copy of the cookie taken before logging out is no use, even after a restart.
+	u, _ := url.Parse(c.url)
+	c.client.Jar.SetCookies(u, []*http.Cookie{{Name: cookieName, Value: session.Value, Path: "/"}})
+	if status := c.do(http.MethodGet, "/api/memories", nil, nil); status != http.StatusUnauthorized {
+		t.Errorf("listing memories with a logged out session: got %d, want %d", status, http.StatusUnauthorized)
+	}
+	restarted, err := newSessionStore(testSecret, revokedSessionsPath, c.sessions.users)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := restarted.valid(session.Value); ok {
+		t.Error("a logged out session is valid again after a restart")
+	}
+
+	cookie, err := c.sessions.issue(testUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sess, ok := c.sessions.valid(cookie.Value); !ok || sess.user != testUser {
+		t.Fatalf("new session = %+v, %v, want a valid session for %s", sess, ok, testUser)
+	}
+	rotated, err := newSessionStore("new-secret", revokedSessionsPath, c.sessions.users)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := rotated.valid(cookie.Value); ok {
+		t.Error("a session survived the secret changing")
+	}
+	tamper := func(part int, value string) string {
+		parts := strings.Split(cookie.Value, ".")
+		parts[part] = value
+		return strings.Join(parts, ".")
+	}
+	if _, ok := c.sessions.valid(tamper(2, "9999999999")); ok {
+		t.Error("a session with its expiry pushed back is valid")
+	}
+	if _, err := setPassword(usersPath, "someone", "", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.sessions.valid(tamper(1, "someone")); ok {
+		t.Error("a session can be passed off as another user's")
+	}
+
+	// Resetting or removing a user ends their sessions.
+	if _, err := setPassword(usersPath, testUser, "new-password", true); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.sessions.valid(cookie.Value); ok {
+		t.Error("a session survived its user's password being reset")
+	}
+	if cookie, err = c.sessions.issue(testUser); err != nil {
+		t.Fatal(err)
+	}
+	if err := removeUser(usersPath, testUser); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.sessions.valid(cookie.Value); ok {
+		t.Error("a session survived its user being removed")
+	}
+	if cookie, err = c.sessions.issue("someone"); err != nil {
+		t.Fatal(err)
+	}
+	c.sessions.now = func() time.Time { return time.Now().Add(sessionLifetime + time.Minute) }
+	if _, ok := c.sessions.valid(cookie.Value); ok {
+		t.Error("an expired session is valid")
+	}
+}
+
+func TestCrossOriginRequestsAreRejected(t *testing.T) {
+	c := newCreator(t)
+	c.login()
+
+	addWIP := func(header http.Header) int {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodPost, c.url+"/api/wips", strings.NewReader(`{"title": "Glastonbury"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header = header
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := c.client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	for name, header := range map[string]http.Header{
+		"no token":                {},
+		"wrong token":             {csrfHeader: {"not-the-token"}},
+		"another origin":          {csrfHeader: {c.csrfToken}, "Origin": {"https://evil.example"}},
+		"another referrer":        {csrfHeader: {c.csrfToken}, "Referer": {"https://evil.example/page"}},
+		"an opaque origin":        {csrfHeader: {c.csrfToken}, "Origin": {"null"}},
+		"a lookalike host":        {csrfHeader: {c.csrfToken}, "Origin": {c.url + ".evil.example"}},
+		"another session's token": {csrfHeader: {c.sessions.csrfToken("someone-else")}},
+	} {
+		if status := addWIP(header); status != http.StatusForbidden {
+			t.Errorf("adding an idea with %s: got %d, want %d", name, status, http.StatusForbidden)
+		}
+	}
+	var entries []WIPEntry
+	c.do(http.MethodGet, "/api/wips", nil, &entries)
+	if len(entries) != 0 {
+		t.Fatalf("rejected requests added %+v", entries)
+	}
+
+	if status := addWIP(http.Header{csrfHeader: {c.csrfToken}, "Origin": {c.url}}); status != http.StatusOK {
+		t.Errorf("adding an idea from the creator's own page: got %d, want %d", status, http.StatusOK)
+	}
+
+	// A form posted from another site can't log in or out either.
+	for _, path := range []string{"/api/login", "/api/logout"} {
+		req, err := http.NewRequest(http.MethodPost, c.url+path, strings.NewReader(url.Values{"user": {testUser}, "password": {testPassword}}.Encode()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Origin", "https://evil.example")
+		resp, err := c.client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("cross-origin POST to %s: got %d, want %d", path, resp.StatusCode, http.StatusForbidden)
+		}
+	}
+	if status := c.do(http.MethodGet, "/api/memories", nil, nil); status != http.StatusOK {
+		t.Errorf("still logged in after a cross-origin logout: got %d", status)
+	}
+}
+
+func TestCreatorOriginBehindAProxy(t *testing.T) {
+	c := newCreator(t)
+	c.login()
+	origin, err := parseOrigin("https://creator.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.sessions.origin = origin
+
+	// The proxy passes requests on with the creator's own address as their Host, so they
+	// only come from its pages if they're from CREATOR_ORIGIN.
+	for from, want := range map[string]int{
+		"https://creator.example":       http.StatusOK,
+		"http://creator.example":        http.StatusForbidden,
+		"https://creator.example.evil":  http.StatusForbidden,
+		"https://creator.example:8443/": http.StatusForbidden,
+	} {
+		if status := c.doWith(http.MethodPost, "/api/wips", http.Header{"Origin": {from}}, AddWIPRequest{Title: "Glastonbury"}, nil); status != want {
+			t.Errorf("adding an idea from %s: got %d, want %d", from, status, want)
+		}
+	}
+
+	for _, bad := range []string{"creator.example", "https://", "ftp://creator.example", "https://creator.example/creator"} {
+		if _, err := parseOrigin(bad); err == nil {
+			t.Errorf("parseOrigin(%q) succeeded", bad)
+		}
+	}
+}
+
+// The users file can be edited by hand, so names that setPassword wouldn't accept still
+// mustn't be able to put markup in the page.
+func TestUserNameIsEscaped(t *testing.T) {
+	c := newCreator(t)
+	name := "<i>sam&co</i>"
+	hash, err := bcrypt.GenerateFromPassword([]byte(testPassword), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	users, err := loadUsers(usersPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := saveUsers(usersPath, append(users, user{Name: name, Hash: string(hash)})); err != nil {
+		t.Fatal(err)
+	}
+	c.loginAs(name, testPassword)
+
+	resp, err := c.client.Get(c.url + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	page, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(page, []byte(name)) || !bytes.Contains(page, []byte("Log out &lt;i&gt;sam&amp;co&lt;/i&gt;")) {
+		t.Error("the user's name wasn't escaped in the page")
+	}
+}
+
+func TestSearch(t *testing.T) {
+	c := newCreator(t)
+	c.login()
+
+	var results []SongResult
+	if status := c.do(http.MethodPost, "/api/search", SearchRequest{Query: "sabrina"}, &results); status != http.StatusOK {
+		t.Fatalf("search: got %d", status)
+	}
+
+	var names []string
+	for _, r := range results {
+		names = append(names, r.Name)
+	}
+	if got, want := strings.Join(names, ", "), "Bed Chem, Juno"; got != want {
+		t.Errorf("search for sabrina found %q, want %q", got, want)
+	}
+
+	bedChem := results[0]
+	if bedChem.AlbumName != "Short n' Sweet" {
+		t.Errorf("album = %q, want Short n' Sweet", bedChem.AlbumName)
+	}
+	if bedChem.ImageName != "bed-chem" {
+		t.Errorf("imageName = %q, want bed-chem", bedChem.ImageName)
+	}
+	if !strings.HasSuffix(bedChem.ImageURL, "-300.jpg") {
+		t.Errorf("imageUrl = %q, want the 300px cover", bedChem.ImageURL)
+	}
+	if len(bedChem.Artists) != 1 || bedChem.Artists[0].Link != "https://open.spotify.com/artist/74KM79TiuVKeVCqs8QtB0B" {
+		t.Errorf("artists = %+v", bedChem.Artists)
+	}
+
+	if status := c.do(http.MethodPost, "/api/search", SearchRequest{}, nil); status != http.StatusBadRequest {
+		t.Errorf("empty search: got %d, want %d", status, http.StatusBadRequest)
+	}
+}
+
+func TestFetchSong(t *testing.T) {
+	c := newCreator(t)
+	c.login()
+
+	for _, link := range []string{
+		"https://open.spotify.com/track/3fCd4GZmcTBqH8DvgSgJFh?si=abc",
+		"spotify:track:3fCd4GZmcTBqH8DvgSgJFh",
+		"3fCd4GZmcTBqH8DvgSgJFh",
+	} {
+		var song SongResult
+		if status := c.do(http.MethodPost, "/api/fetch-song", FetchRequest{URL: link}, &song); status != http.StatusOK {
+			t.Errorf("fetching %s: got %d", link, status)
+			continue
+		}
+		if song.Name != "Sexy to Someone" || song.AlbumName != "Charm" || song.SongLink != "https://open.spotify.com/track/3fCd4GZmcTBqH8DvgSgJFh" {
+			t.Errorf("fetching %s: got %+v", link, song)
+		}
+	}
+
+	if status := c.do(http.MethodPost, "/api/fetch-song", FetchRequest{URL: "nosuchtrack"}, nil); status != http.StatusBadGateway {
+		t.Errorf("fetching an unknown track: got %d, want %d", status, http.StatusBadGateway)
+	}
+}
+
+func TestSaveAndLoadMemory(t *testing.T) {
+	c := newCreator(t)
+	c.login()
+
+	var results []SongResult
+	c.do(http.MethodPost, "/api/search", SearchRequest{Query: "tipsy"}, &results)
+	if len(results) != 1 {
+		t.Fatalf("search for tipsy found %d songs, want 1", len(results))
+	}
+	found := results[0]
+
+	save := SaveRequest{
+		OutputTitle: "lords",
+		Title:       "Lord's",
+		Subtitle:    "Winning at Lord's",
+		Date:        "Summer 2025",
+		Content:     "We **won**.",
+		Songs: []SaveSong{{
+			Name:            found.Name,
+			SongLink:        found.SongLink,
+			Artists:         found.Artists,
+			RelevantDate:    "2024",
+			ImageName:       found.ImageName,
+			SpotifyImageURL: found.ImageURL,
+		}},
+		Tags:   []string{" cricket ", "Cricket", ""},
+		Places: []sonostalgia.Place{{Name: "Lord's", Lat: 51.53, Lon: -0.17}},
+	}
+	if status := c.save(save, "", nil); status != http.StatusOK {
+		t.Fatalf("save: got %d", status)
+	}
+
+	// The cover art is downloaded from the API and saved as an asset.
+	f, err := os.Open(filepath.Join("src", "assets", "a-bar-song-tipsy.jpg"))
+	if err != nil {
+		t.Fatalf("cover art wasn't saved: %v", err)
+	}
+	cover, err := jpeg.Decode(f)
+	f.Close()
+	if err != nil {
+		t.Fatalf("cover art isn't a jpeg: %v", err)
+	}
+	if width := cover.Bounds().Dx(); width != 300 {
+		t.Errorf("cover art is %dpx wide, want 300", width)
+	}
+
+	saved, err := sonostalgia.LoadMemory(filepath.Join("src", "memories", "lords.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Published.IsZero() {
+		t.Error("published wasn't set on first save")
+	}
+	if saved.Author != testUser || saved.EditedBy != "" {
+		t.Errorf("first save has author %q and editor %q, want just the author %s", saved.Author, saved.EditedBy, testUser)
+	}
+	if got := strings.Join(saved.Tags, ","); got != "cricket" {
+		t.Errorf("tags = %q, want cricket", got)
+	}
+
+	var memory MemoryResponse
+	if status := c.do(http.MethodGet, "/api/memory?slug=lords", nil, &memory); status != http.StatusOK {
+		t.Fatalf("loading the memory: got %d", status)
+	}
+	if memory.Date != "Summer 2025" || memory.Content != "We **won**." {
+		t.Errorf("loaded date %q and content %q", memory.Date, memory.Content)
+	}
+	if len(memory.Songs) != 1 || memory.Songs[0].ImageLink != "assets/a-bar-song-tipsy.jpg" || memory.Songs[0].RelevantDate != "2024" {
+		t.Errorf("loaded songs = %+v", memory.Songs)
+	}
+	if len(memory.Places) != 1 || memory.Places[0].Name != "Lord's" {
+		t.Errorf("loaded places = %+v", memory.Places)
+	}
+
+	var list []MemoryListItem
+	c.do(http.MethodGet, "/api/memories", nil, &list)
+	if len(list) != 1 || list[0].OutputTitle != "lords" {
+		t.Errorf("memory list = %+v", list)
+	}
+
+	// Editing keeps the original publish time, author and the existing image.
+	if _, err := setPassword(usersPath, "editor", "editor-password", false); err != nil {
+		t.Fatal(err)
+	}
+	c.loginAs("editor", "editor-password")
+	save.Title = "Lord's, again"
+	save.Songs[0].SpotifyImageURL = ""
+	save.Songs[0].ExistingImageLink = "assets/a-bar-song-tipsy.jpg"
+	if status := c.save(save, memory.Version, nil); status != http.StatusOK {
+		t.Fatalf("second save: got %d", status)
+	}
+	edited, err := sonostalgia.LoadMemory(filepath.Join("src", "memories", "lords.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !edited.Published.Equal(saved.Published) {
+		t.Errorf("published changed from %v to %v on edit", saved.Published, edited.Published)
+	}
+	if edited.Title != "Lord's, again" || edited.Songs[0].ImageLink != "assets/a-bar-song-tipsy.jpg" {
+		t.Errorf("edited memory = %+v", edited)
+	}
+	if edited.Author != testUser || edited.EditedBy != "editor" {
+		t.Errorf("edited memory has author %q and editor %q, want %s and editor", edited.Author, edited.EditedBy, testUser)
+	}
+}
+
+func TestSaveConflicts(t *testing.T) {
+	c := newCreator(t)
+	c.login()
+	path := filepath.Join("src", "memories", "gig.yaml")
+
+	save := SaveRequest{OutputTitle: "gig", Title: "The Gig", Date: "2020"}
+	if status := c.doWith(http.MethodPost, "/api/save", nil, save, nil); status != http.StatusPreconditionRequired {
+		t.Errorf("save without a version: got %d, want %d", status, http.StatusPreconditionRequired)
+	}
+	if status := c.save(save, "", nil); status != http.StatusOK {
+		t.Fatalf("save: got %d", status)
+	}
+	var conflict ConflictResponse
+	if status := c.save(save, "", &conflict); status != http.StatusConflict || conflict.Current == nil || conflict.Current.Title != "The Gig" {
+		t.Errorf("saving a new memory over an existing one: got %d with %+v, want a conflict with the existing one", status, conflict)
+	}
+
+	// Two people load the memory, and both save.
+	var loaded MemoryResponse
+	c.do(http.MethodGet, "/api/memory?slug=gig", nil, &loaded)
+	if loaded.Version == "" {
+		t.Fatal("the memory has no version")
+	}
+	save.Title = "The Gig, by one"
+	if status := c.save(save, loaded.Version, nil); status != http.StatusOK {
+		t.Fatalf("first save of the loaded version: got %d", status)
+	}
+	save.Title = "The Gig, by the other"
+	conflict = ConflictResponse{}
+	if status := c.save(save, loaded.Version, &conflict); status != http.StatusConflict {
+		t.Fatalf("second save of the loaded version: got %d, want %d", status, http.StatusConflict)
+	}
+	if conflict.Current == nil || conflict.Current.Title != "The Gig, by one" || conflict.Current.Version == loaded.Version {
+		t.Errorf("conflict = %+v, want the first save", conflict.Current)
+	}
+	if saved, _ := sonostalgia.LoadMemory(path); saved.Title != "The Gig, by one" {
+		t.Errorf("a conflicting save overwrote the memory with %q", saved.Title)
+	}
+	// Having merged, saving against the version the conflict came with goes through.
+	if status := c.save(save, conflict.Current.Version, nil); status != http.StatusOK {
+		t.Errorf("save after merging: got %d", status)
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	conflict = ConflictResponse{}
+	if status := c.save(save, loaded.Version, &conflict); status != http.StatusConflict || conflict.Current != nil {
+		t.Errorf("saving a deleted memory: got %d with %+v, want a conflict with nothing current", status, conflict)
+	}
+}
+
+// TestMergeShowsArtistNames runs the page's mergeText on songs as they're saved and as
+// they're loaded, so needs node, which nothing else here does.
+func TestMergeShowsArtistNames(t *testing.T) {
+	node, err := exec.LookPath("node")
+	if err != nil {
+		t.Skip("node isn't installed")
+	}
+	mergeText := regexp.MustCompile(`(?s)\n  function mergeText\(.*?\n  }\n`).Find(indexHTML)
+	if mergeText == nil {
+		t.Fatal("index.html has no mergeText")
+	}
+
+	for _, memory := range []any{
+		SaveRequest{Songs: []SaveSong{{Name: "Sunrise", Artists: []sonostalgia.Artist{{Name: "The Fixtures"}, {Name: "Golden Sample"}}}}},
+		MemoryResponse{Songs: []SongResponse{{Name: "Sunrise", Artists: []ArtistResponse{{Name: "The Fixtures"}, {Name: "Golden Sample"}}}}},
+	} {
+		data, err := json.Marshal(memory)
+		if err != nil {
+			t.Fatal(err)
+		}
+		script := string(mergeText) + "process.stdout.write(mergeText(" + string(data) + ", 'songs'));"
+		got, err := exec.Command(node, "-e", script).Output()
+		if err != nil {
+			t.Fatalf("running mergeText: %v", err)
+		}
+		if want := "Sunrise — The Fixtures, Golden Sample"; string(got) != want {
+			t.Errorf("mergeText(%T) = %q, want %q", memory, got, want)
+		}
+	}
+}
+
+func TestSaveRejectsBadInput(t *testing.T) {
+	c := newCreator(t)
+	c.login()
+
+	// A memory whose file isn't named after it, which saving another with its slug would clash with.
+	existing := filepath.Join("src", "memories", "eve.yaml")
+	if err := os.WriteFile(existing, []byte("outputTitle: eve-online\ntitle: Eve\ndate: \"2019\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var results []SongResult
+	c.do(http.MethodPost, "/api/search", SearchRequest{Query: "tipsy"}, &results)
+	if len(results) != 1 {
+		t.Fatalf("search for tipsy found %d songs, want 1", len(results))
+	}
+	withCover := SaveSong{Name: "Tipsy", Artists: results[0].Artists, ImageName: "tipsy", SpotifyImageURL: results[0].ImageURL}
+
+	for name, req := range map[string]SaveRequest{
+		"bad slug":           {OutputTitle: "Not A Slug", Title: "x", Date: "2020"},
+		"bad date":           {OutputTitle: "bad-date", Title: "x", Date: "the other day"},
+		"bad relevant date":  {OutputTitle: "bad-song", Title: "x", Date: "2020", Songs: []SaveSong{{Name: "x", RelevantDate: "someday"}}},
+		"no title":           {OutputTitle: "no-title", Date: "2020", Songs: []SaveSong{withCover}},
+		"no date":            {OutputTitle: "no-date", Title: "x"},
+		"duplicate slug":     {OutputTitle: "eve-online", Title: "x", Date: "2020"},
+		"song without name":  {OutputTitle: "no-name", Title: "x", Date: "2020", Songs: []SaveSong{{Artists: withCover.Artists}}},
+		"missing image":      {OutputTitle: "no-image", Title: "x", Date: "2020", Songs: []SaveSong{{Name: "x", Artists: withCover.Artists, ExistingImageLink: "assets/gone.jpg"}}},
+		"place without name": {OutputTitle: "no-place-name", Title: "x", Date: "2020", Places: []sonostalgia.Place{{Name: " ", Lat: 51.5, Lon: -0.1}}},
+		"latitude too far":   {OutputTitle: "bad-lat", Title: "x", Date: "2020", Places: []sonostalgia.Place{{Name: "Beyond the pole", Lat: 91, Lon: 0}}},
+		"longitude too far":  {OutputTitle: "bad-lon", Title: "x", Date: "2020", Places: []sonostalgia.Place{{Name: "Past the dateline", Lat: 0, Lon: -180.5}}},
+	} {
+		if status := c.save(req, "", nil); status != http.StatusBadRequest {
+			t.Errorf("%s: got %d, want %d", name, status, http.StatusBadRequest)
+		}
+	}
+
+	files, _ := filepath.Glob("src/memories/*")
+	if len(files) != 1 {
+		t.Errorf("rejected saves wrote %v", files)
+	}
+	if assets, _ := filepath.Glob("src/assets/*"); len(assets) != 0 {
+		t.Errorf("rejected saves downloaded %v", assets)
+	}
+}
+
+func TestWIPs(t *testing.T) {
+	c := newCreator(t)
+	c.login()
+
+	var entries []WIPEntry
+	c.do(http.MethodGet, "/api/wips", nil, &entries)
+	if len(entries) != 0 {
+		t.Fatalf("started with WIPs %+v", entries)
+	}
+
+	if status := c.do(http.MethodPost, "/api/wips", AddWIPRequest{Title: "  "}, nil); status != http.StatusBadRequest {
+		t.Errorf("adding an untitled WIP: got %d, want %d", status, http.StatusBadRequest)
+	}
+
+	var added WIPEntry
+	if status := c.do(http.MethodPost, "/api/wips", AddWIPRequest{Title: " Glastonbury ", Notes: "2019"}, &added); status != http.StatusOK {
+		t.Fatalf("adding a WIP: got %d", status)
+	}
+	if added.Title != "Glastonbury" || added.ID == "" || added.Author != testUser {
+		t.Errorf("added %+v", added)
+	}
+
+	c.do(http.MethodGet, "/api/wips", nil, &entries)
+	if len(entries) != 1 || entries[0].ID != added.ID {
+		t.Errorf("WIPs after adding = %+v", entries)
+	}
+
+	if status := c.do(http.MethodDelete, "/api/wip?id="+added.ID, nil, nil); status != http.StatusNoContent {
+		t.Errorf("deleting: got %d", status)
+	}
+	entries = nil
+	c.do(http.MethodGet, "/api/wips", nil, &entries)
+	if len(entries) != 0 {
+		t.Errorf("WIPs after deleting = %+v", entries)
+	}
+}
+
+func TestProviderIsOnlyUsedForLookups(t *testing.T) {
+	c := newCreator(t)
+	c.login()
+
+	c.do(http.MethodGet, "/api/memories", nil, nil)
+	c.do(http.MethodGet, "/api/wips", nil, nil)
+	if n := c.spotify.Requests(); n != 0 {
+		t.Errorf("listing memories and WIPs made %d API requests", n)
+	}
+
+	c.do(http.MethodPost, "/api/fetch-song", FetchRequest{URL: "21B4gaTWnTkuSh77iWEXdS"}, nil)
+	if n := c.spotify.Requests(); n != 2 {
+		t.Errorf("fetching a song made %d API requests, want 2 (track and album)", n)
+	}
+}
+
+func TestMusicBrainzLookups(t *testing.T) {
+	c := newCreator(t)
+	c.login()
+
+	var results []SongResult
+	if status := c.do(http.MethodPost, "/api/search", SearchRequest{Query: "juno", Provider: "musicbrainz"}, &results); status != http.StatusOK {
+		t.Fatalf("search: got %d", status)
+	}
+	if len(results) != 2 || results[0].Name != "Juno" || results[0].AlbumName != "Short n’ Sweet" {
+		t.Fatalf("search for juno found %+v", results)
+	}
+
+	var song SongResult
+	link := "https://musicbrainz.org/recording/7b3e1f9a-2c4d-4e5f-8a6b-9c0d1e2f3a4b"
+	if status := c.do(http.MethodPost, "/api/fetch-song", FetchRequest{URL: link, Provider: "musicbrainz"}, &song); status != http.StatusOK {
+		t.Fatalf("fetching %s: got %d", link, status)
+	}
+	if song.Name != "Bed Chem" || song.SongLink != link || song.ImageName != "bed-chem" {
+		t.Errorf("fetched %+v", song)
+	}
+	if len(song.Artists) != 1 || song.Artists[0].Link != "https://musicbrainz.org/artist/1882fe91-cdd9-49c9-9956-8e06a3810bd4" {
+		t.Errorf("artists = %+v", song.Artists)
+	}
+
+	// The song is saved just as a Spotify one would be, cover art included.
+	save := SaveRequest{
+		OutputTitle: "bed-chem",
+		Title:       "Bed Chem",
+		Date:        "2024",
+		Songs: []SaveSong{{
+			Name:            song.Name,
+			SongLink:        song.SongLink,
+			Artists:         song.Artists,
+			ImageName:       song.ImageName,
+			SpotifyImageURL: song.ImageURL,
+		}},
+	}
+	if status := c.save(save, "", nil); status != http.StatusOK {
+		t.Fatalf("save: got %d", status)
+	}
+	saved, err := sonostalgia.LoadMemory(filepath.Join("src", "memories", "bed-chem.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Songs[0].ImageLink != "assets/bed-chem.jpg" {
+		t.Errorf("imageLink = %q", saved.Songs[0].ImageLink)
+	}
+	if _, err := os.Stat(filepath.Join("src", "assets", "bed-chem.jpg")); err != nil {
+		t.Errorf("cover art wasn't saved: %v", err)
+	}
+
+	if n := c.spotify.Requests(); n != 0 {
+		t.Errorf("MusicBrainz lookups made %d Spotify requests", n)
+	}
+	if misses := c.musicBrainz.Misses(); len(misses) > 0 {
+		t.Errorf("requests with no recorded response: %v", misses)
+	}
+
+	if status := c.do(http.MethodPost, "/api/search", SearchRequest{Query: "juno", Provider: "deezer"}, nil); status != http.StatusBadRequest {
+		t.Errorf("searching an unknown provider: got %d, want %d", status, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/alexflint/go-arg"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
+)
+
+// usersPath holds everyone who can log in to the creator, with bcrypt hashes of their passwords.
+const usersPath = ".creator-users.yaml"
+
+var (
+	validUserRe = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,31}$`)
+
+	// bcryptCost is how much work hashing a password takes; tests turn it down.
+	bcryptCost = bcrypt.DefaultCost
+	// noSuchUser is compared against when a login names nobody, so that it takes as long as
+	// getting a real user's password wrong and doesn't give away who has an account.
+	noSuchUser = sync.OnceValue(func() []byte {
+		hash, _ := bcrypt.GenerateFromPassword([]byte("no such user"), bcrypt.DefaultCost)
+		return hash
+	})
+)
+
+type user struct {
+	Name string `yaml:"name"`
+	Hash string `yaml:"hash"`
+}
+
+func loadUsers(path string) ([]user, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var users []user
+	if err := yaml.Unmarshal(data, &users); err != nil {
+		return nil, fmt.Errorf("reading users from %s: %w", path, err)
+	}
+	return users, nil
+}
+
+func saveUsers(path string, users []user) error {
+	data, err := yaml.Marshal(users)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// setPassword adds name to the users file with password, or gives them password if they're
+// already there. A blank password gets a random one, which is returned.
+func setPassword(path, name, password string, mustExist bool) (string, error) {
+	if !validUserRe.MatchString(name) {
+		return "", fmt.Errorf("user names must be lowercase letters, digits, - and _, starting with a letter, not %q", name)
+	}
+	users, err := loadUsers(path)
+	if err != nil {
+		return "", err
+	}
+	i := slices.IndexFunc(users, func(u user) bool { return u.Name == name })
+	if mustExist && i < 0 {
+		return "", fmt.Errorf("there's no user called %s", name)
+	}
+	if !mustExist && i >= 0 {
+		return "", fmt.Errorf("there's already a user called %s", name)
+	}
+
+	if password == "" {
+		random := make([]byte, 18)
+		if _, err := rand.Read(random); err != nil {
+			return "", err
+		}
+		password = base64.RawURLEncoding.EncodeToString(random)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcryptCost)
+	if err != nil {
+		return "", err
+	}
+	if i < 0 {
+		users = append(users, user{Name: name, Hash: string(hash)})
+	} else {
+		users[i].Hash = string(hash)
+	}
+	return password, saveUsers(path, users)
+}
+
+func removeUser(path, name string) error {
+	users, err := loadUsers(path)
+	if err != nil {
+		return err
+	}
+	kept := slices.DeleteFunc(users, func(u user) bool { return u.Name == name })
+	if len(kept) == len(users) {
+		return fmt.Errorf("there's no user called %s", name)
+	}
+	return saveUsers(path, kept)
+}
+
+// userStore looks users up for logins and sessions. The users file is read afresh each time,
+// which it's small enough for, so that users added, removed or reset from the command line
+// take effect without a restart.
+type userStore struct {
+	path string
+}
+
+// hash returns name's password hash, or "" if there's no such user.
+func (u *userStore) hash(name string) (string, error) {
+	users, err := loadUsers(u.path)
+	if err != nil {
+		return "", err
+	}
+	for _, user := range users {
+		if user.Name == name {
+			return user.Hash, nil
+		}
+	}
+	return "", nil
+}
+
+// authenticate reports whether password is name's.
+func (u *userStore) authenticate(name, password string) (bool, error) {
+	hash, err := u.hash(name)
+	if err != nil {
+		return false, err
+	}
+	if hash == "" {
+		bcrypt.CompareHashAndPassword(noSuchUser(), []byte(password))
+		return false, nil
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil, nil
+}
+
+// runUsers carries out a users subcommand on the users file at path.
+func runUsers(p *arg.Parser, path string) error {
+	readPassword := func(fromStdin bool) (string, error) {
+		if !fromStdin {
+			return "", nil
+		}
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return "", err
+		}
+		password := strings.TrimRight(line, "\r\n")
+		if password == "" {
+			return "", errors.New("no password on standard input")
+		}
+		return password, nil
+	}
+	report := func(verb, name, given, password string) {
+		if given == "" {
+			fmt.Printf("%s %s, whose password is now %s\n", verb, name, password)
+		} else {
+			fmt.Printf("%s %s\n", verb, name)
+		}
+	}
+
+	switch cmd := p.Subcommand().(type) {
+	case *AddUserCmd:
+		given, err := readPassword(cmd.PasswordStdin)
+		if err != nil {
+			return err
+		}
+		password, err := setPassword(path, cmd.Name, given, false)
+		if err != nil {
+			return err
+		}
+		report("added", cmd.Name, given, password)
+	case *ResetUserCmd:
+		given, err := readPassword(cmd.PasswordStdin)
+		if err != nil {
+			return err
+		}
+		password, err := setPassword(path, cmd.Name, given, true)
+		if err != nil {
+			return err
+		}
+		report("reset", cmd.Name, given, password)
+	case *RemoveUserCmd:
+		if err := removeUser(path, cmd.Name); err != nil {
+			return err
+		}
+		fmt.Printf("removed %s\n", cmd.Name)
+	case *ListUsersCmd:
+		users, err := loadUsers(path)
+		if err != nil {
+			return err
+		}
+		for _, user := range users {
+			fmt.Println(user.Name)
+		}
+	default:
+		p.FailSubcommand("choose add, remove, reset or list", "users")
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// fastHashing turns bcryptCost down for the rest of a test. Hashing passwords properly is slow
+// on purpose, which tests don't need.
+func fastHashing(t *testing.T) {
+	saved := bcryptCost
+	bcryptCost = bcrypt.MinCost
+	t.Cleanup(func() { bcryptCost = saved })
+}
+
+func TestUsers(t *testing.T) {
+	fastHashing(t)
+	path := filepath.Join(t.TempDir(), "users.yaml")
+	users := &userStore{path: path}
+
+	password, err := setPassword(path, "sam", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(password) < 16 {
+		t.Errorf("made up password %q is too short", password)
+	}
+	if _, err := setPassword(path, "sam", "again", false); err == nil {
+		t.Error("added sam twice")
+	}
+	if _, err := setPassword(path, "Not Valid", "x", false); err == nil {
+		t.Error("added a user with an invalid name")
+	}
+	if _, err := setPassword(path, "alex", "x", true); err == nil {
+		t.Error("reset the password of a user who doesn't exist")
+	}
+
+	if ok, err := users.authenticate("sam", password); err != nil || !ok {
+		t.Errorf("sam can't log in with their password: %v", err)
+	}
+	if ok, _ := users.authenticate("sam", "guess"); ok {
+		t.Error("sam can log in with the wrong password")
+	}
+	if ok, _ := users.authenticate("alex", password); ok {
+		t.Error("someone who isn't a user can log in")
+	}
+
+	if _, err := setPassword(path, "sam", "chosen", true); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := users.authenticate("sam", password); ok {
+		t.Error("sam's old password still works after a reset")
+	}
+	if ok, _ := users.authenticate("sam", "chosen"); !ok {
+		t.Error("sam's new password doesn't work")
+	}
+
+	if err := removeUser(path, "sam"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := users.authenticate("sam", "chosen"); ok {
+		t.Error("a removed user can still log in")
+	}
+	if err := removeUser(path, "sam"); err == nil {
+		t.Error("removed sam twice")
+	}
+}
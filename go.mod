@@ -3,15 +3,18 @@ module github.com/azoghal/sonostalgia
 go 1.24.5
 
 require (
+	github.com/HugoSmits86/nativewebp v0.9.3
+	github.com/alexflint/go-arg v1.6.0
 	github.com/joho/godotenv v1.5.1
 	github.com/yuin/goldmark v1.7.13
 	github.com/zmb3/spotify/v2 v2.4.3
+	golang.org/x/crypto v0.21.0
+	golang.org/x/image v0.36.0
 	golang.org/x/oauth2 v0.0.0-20210810183815-faf39c7919d5
 	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
-	github.com/alexflint/go-arg v1.6.0 // indirect
 	github.com/alexflint/go-scalar v1.2.0 // indirect
 	github.com/golang/protobuf v1.5.2 // indirect
 	golang.org/x/net v0.23.0 // indirect
@@ -1,23 +1,17 @@
 package main
 
 import (
+	"cmp"
 	"context"
-	"errors"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
 	"os"
-	"strings"
 	"text/template"
-	"unicode"
 
 	"github.com/alexflint/go-arg"
 	sonostalgia "github.com/azoghal/sonostalgia/src"
+	"github.com/azoghal/sonostalgia/src/metadata"
 	"github.com/joho/godotenv"
-	spotify "github.com/zmb3/spotify/v2"
-	spotifyauth "github.com/zmb3/spotify/v2/auth"
-	"golang.org/x/oauth2/clientcredentials"
 )
 
 /* fetches all the info to populate a song in order to populate song(s) in memories
@@ -31,15 +25,12 @@ import (
 
 */
 
-const (
-	minDesiredWidth = 100 // if we can, make sure all images are at least 100px width/height
-	maxDesiredWidth = 350 // if we can, try to keep the images a reasonable size
-)
-
 type Args struct {
 	MemoryOutputTitle string   `arg:"-n,--name,required"      help:"the output name of the memory, e.g. eve-online"`
-	SongIds           []string `arg:"--songids,required"      help:"list of spotify ids for the main songs"`
-	OtherSongIds      []string `arg:"--othersongids" help:"list of spotify ids for the other songs"`
+	SongIds           []string `arg:"--songids,required"      help:"list of ids (or links) for the main songs"`
+	OtherSongIds      []string `arg:"--othersongids" help:"list of ids (or links) for the other songs"`
+	Provider          string   `arg:"--provider"     default:"spotify" help:"where to look songs up: spotify or musicbrainz"`
+	APIURL            string   `arg:"--api-url"      help:"send API requests here instead, e.g. to a fake server (or set SPOTIFY_API_URL / MUSICBRAINZ_API_URL)"`
 }
 
 type TemplateParams struct {
@@ -76,30 +67,32 @@ func main() {
 		log.Fatal("failed to load env file")
 	}
 
-	spotifyClientId := os.Getenv("SPOTIFY_CLIENT_ID")
-	spotifyClientSecret := os.Getenv("SPOTIFY_CLIENT_SECRET")
-
 	ctx := context.Background()
-	config := &clientcredentials.Config{
-		ClientID:     spotifyClientId,
-		ClientSecret: spotifyClientSecret,
-		TokenURL:     spotifyauth.TokenURL,
-	}
-	token, err := config.Token(ctx)
-	if err != nil {
-		log.Fatalf("couldn't get token: %v", err)
+	var provider metadata.MetadataProvider
+	switch args.Provider {
+	case metadata.SpotifyProvider:
+		provider = metadata.NewSpotify(ctx, metadata.SpotifyConfig{
+			ClientID:     os.Getenv("SPOTIFY_CLIENT_ID"),
+			ClientSecret: os.Getenv("SPOTIFY_CLIENT_SECRET"),
+			Market:       os.Getenv("SPOTIFY_MARKET"),
+			BaseURL:      cmp.Or(args.APIURL, os.Getenv("SPOTIFY_API_URL")),
+		})
+	case metadata.MusicBrainzProvider:
+		provider = metadata.NewMusicBrainz(metadata.MusicBrainzConfig{
+			BaseURL:     cmp.Or(args.APIURL, os.Getenv("MUSICBRAINZ_API_URL")),
+			CoverArtURL: cmp.Or(args.APIURL, os.Getenv("COVERART_API_URL")),
+		})
+	default:
+		log.Fatalf("unknown provider %q, expected spotify or musicbrainz", args.Provider)
 	}
 
-	httpClient := spotifyauth.New().Client(ctx, token)
-	client := spotify.New(httpClient)
-
 	fmt.Println()
 
 	songs := []sonostalgia.Song{}
 	otherSongs := []sonostalgia.Song{}
 
 	for _, songId := range args.SongIds {
-		song, err := lookupSongById(ctx, client, songId)
+		song, err := lookupSongById(ctx, provider, songId)
 		if err != nil {
 			log.Printf("FAILED to lookup song: %s\n", err)
 			continue
@@ -109,7 +102,7 @@ func main() {
 	}
 
 	for _, songId := range args.OtherSongIds {
-		song, err := lookupSongById(ctx, client, songId)
+		song, err := lookupSongById(ctx, provider, songId)
 		if err != nil {
 			log.Printf("FAILED to lookup song: %s\n", err)
 			continue
@@ -130,108 +123,39 @@ func main() {
 	}
 }
 
-func lookupSongById(ctx context.Context, client *spotify.Client, id string) (*sonostalgia.Song, error) {
-
-	testId := spotify.ID(id)
-
-	track, err := client.GetTrack(ctx, testId, spotify.Market("GB"))
+func lookupSongById(ctx context.Context, provider metadata.MetadataProvider, id string) (*sonostalgia.Song, error) {
+	track, err := provider.GetTrack(ctx, id)
 	if err != nil {
-		return nil, errors.New("track request failed")
+		return nil, err
 	}
 
-	album, err := client.GetAlbum(ctx, track.Album.ID, spotify.Market("GB"))
+	imageURL, err := provider.GetAlbumArt(ctx, track.AlbumID)
 	if err != nil {
-		return nil, errors.New("album request failed")
+		return nil, err
 	}
 
-	artists := []sonostalgia.Artist{}
-	for _, artist := range track.Artists {
-		artists = append(artists, sonostalgia.Artist{
-			Name: artist.Name,
-			Link: artist.ExternalURLs["spotify"],
-		})
-	}
-
-	bestImageAssetUrl := fetchBestImage(album.Images, "songfetcher/output/assets", makeImageName(track.Name))
-
 	// RelevantDate left empty as it needs user input
 	song := &sonostalgia.Song{
 		Name:      track.Name,
-		Artists:   artists,
-		SongLink:  track.ExternalURLs["spotify"],
-		ImageLink: bestImageAssetUrl,
+		Artists:   track.Artists,
+		SongLink:  track.Link,
+		ImageLink: fetchImage(imageURL, "songfetcher/output/assets", metadata.ImageName(track.Name)),
 	}
 
 	return song, nil
 }
 
-func makeImageName(trackName string) string {
-	alphaOnly := strings.Map(func(r rune) rune {
-		if unicode.IsLetter(r) || unicode.IsNumber(r) || unicode.IsSpace(r) {
-			return r
-		}
-		return -1
-	}, trackName)
-
-	lowerAlphaOnly := strings.ToLower(alphaOnly)
-
-	return strings.Join(strings.Fields(lowerAlphaOnly), "-")
-}
-
-// fetchBestImage finds and downloads the image which matches the most constraints.
-// it will name the downloaded artefact outputName.<ext> where ext = jpg,png
-// if there are no images, the empty string will be returned
-func fetchBestImage(images []spotify.Image, outputDir string, outputName string) string {
-
-	var image *spotify.Image = nil
-	bestScore := 0
-
-	for _, img := range images {
-		width := int(img.Width)
-		score := 1
-		if width < maxDesiredWidth {
-			score += 1
-		}
-		if width > minDesiredWidth {
-			score += 1
-		}
-		if score > bestScore {
-			image = &img
-			bestScore = score
-		}
+// fetchImage downloads the image at url, naming it outputName.jpg.
+// if there is no image, the empty string will be returned
+func fetchImage(url string, outputDir string, outputName string) string {
+	if url == "" {
+		return ""
 	}
 
-	// download
 	outputFilename := fmt.Sprintf("%s/%s.jpg", outputDir, outputName)
-	err := downloadImage(image.URL, outputFilename)
-	if err != nil {
+	if err := metadata.DownloadImage(url, outputFilename); err != nil {
 		log.Printf("failed to download image: %v", err)
 	}
 
 	return fmt.Sprintf("assets/%s.jpg", outputName)
 }
-
-func downloadImage(url string, filepath string) error {
-	resp, err := http.Get(url)
-	if err != nil {
-		return fmt.Errorf("failed to fetch URL: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("bad status: %s", resp.Status)
-	}
-
-	file, err := os.Create(filepath)
-	if err != nil {
-		return fmt.Errorf("failed to create file: %w", err)
-	}
-	defer file.Close()
-
-	_, err = io.Copy(file, resp.Body)
-	if err != nil {
-		return fmt.Errorf("failed to write file: %w", err)
-	}
-
-	return nil
-}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/alexflint/go-arg"
+
+	"github.com/azoghal/sonostalgia/src/metadata/spotifyfake"
+)
+
+// Serves a stand-in for the Spotify API, so the creator and songfetcher can run offline:
+//
+//	SPOTIFY_API_URL=http://localhost:8766 ./build/creator
+
+type Args struct {
+	Addr string `arg:"--addr" default:"localhost:8766" help:"address to serve the fake API on"`
+}
+
+func main() {
+	var args Args
+	arg.MustParse(&args)
+
+	fmt.Printf("Fake Spotify API → http://%s\n", args.Addr)
+	log.Fatal(http.ListenAndServe(args.Addr, spotifyfake.New()))
+}
@@ -0,0 +1,115 @@
+package sonostalgia
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Artists is the artist index.
+type Artists struct {
+	Artists []ArtistPage
+}
+
+// ArtistPage gathers every memory and song featuring a single artist.
+type ArtistPage struct {
+	Name     string
+	Slug     string
+	Link     string
+	Songs    []Appearance
+	Memories []Memory
+}
+
+// Appearances counts every song entry featuring the artist, across all memories.
+func (a ArtistPage) Appearances() int {
+	return len(a.Songs)
+}
+
+// Slugify turns a name into something safe to use in a file name,
+// e.g. "Florence + The Machine" => "florence-the-machine".
+func Slugify(name string) string {
+	alpha := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsNumber(r) {
+			return r
+		}
+		return ' '
+	}, name)
+	return strings.Join(strings.Fields(strings.ToLower(alpha)), "-")
+}
+
+// assignArtistSlugs gives every artist a unique slug, storing it on each Artist in place.
+// Names that slugify to the same thing get a numeric suffix, allocated in name order so
+// that slugs are stable between builds.
+func assignArtistSlugs(memories []Memory) {
+	names := map[string]struct{}{}
+	forEachSong(memories, func(_ Memory, song Song, _ bool) {
+		for _, artist := range song.Artists {
+			names[artist.Name] = struct{}{}
+		}
+	})
+
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	slugs := map[string]string{}
+	taken := map[string]bool{}
+	for _, name := range sorted {
+		base := Slugify(name)
+		if base == "" {
+			base = "artist"
+		}
+		slug := base
+		for i := 2; taken[slug]; i++ {
+			slug = fmt.Sprintf("%s-%d", base, i)
+		}
+		taken[slug] = true
+		slugs[name] = slug
+	}
+
+	for _, memory := range memories {
+		for _, songs := range [][]Song{memory.Songs, memory.OtherSongs} {
+			for i := range songs {
+				for j := range songs[i].Artists {
+					songs[i].Artists[j].Slug = slugs[songs[i].Artists[j].Name]
+				}
+			}
+		}
+	}
+}
+
+// buildArtistPages groups songs and memories by artist, most frequently appearing first.
+func buildArtistPages(memories []Memory) []ArtistPage {
+	bySlug := map[string]*ArtistPage{}
+	forEachSong(memories, func(memory Memory, song Song, other bool) {
+		for _, artist := range song.Artists {
+			page, ok := bySlug[artist.Slug]
+			if !ok {
+				page = &ArtistPage{Name: artist.Name, Slug: artist.Slug}
+				bySlug[artist.Slug] = page
+			}
+			if page.Link == "" {
+				page.Link = artist.Link
+			}
+			page.Songs = append(page.Songs, Appearance{Song: song, Memory: memory, Other: other})
+			if n := len(page.Memories); n == 0 || page.Memories[n-1].OutputTitle != memory.OutputTitle {
+				page.Memories = append(page.Memories, memory)
+			}
+		}
+	})
+
+	pages := make([]ArtistPage, 0, len(bySlug))
+	for _, page := range bySlug {
+		pages = append(pages, *page)
+	}
+	sort.Slice(pages, func(i, j int) bool {
+		if pages[i].Appearances() != pages[j].Appearances() {
+			return pages[i].Appearances() > pages[j].Appearances()
+		}
+		return strings.ToLower(pages[i].Name) < strings.ToLower(pages[j].Name)
+	})
+	return pages
+}
@@ -0,0 +1,435 @@
+package sonostalgia
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// now is swapped out when the current time needs to be pinned, e.g. when
+// resolving an open range like "2019 - present".
+var now = time.Now
+
+// Precision records how much of a DatePoint is actually known.
+type Precision int
+
+const (
+	PrecisionNone Precision = iota
+	PrecisionYear
+	PrecisionSeason
+	PrecisionMonth
+	PrecisionDay
+)
+
+// Season covers the loose parts of a year memories tend to be pinned to.
+// Easter and Christmas aren't seasons, but they get written the same way ("Easter 2022").
+type Season int
+
+const (
+	NoSeason Season = iota
+	Spring
+	Summer
+	Autumn
+	Winter
+	Easter
+	Christmas
+)
+
+var seasonNames = map[string]Season{
+	"spring":    Spring,
+	"summer":    Summer,
+	"autumn":    Autumn,
+	"fall":      Autumn,
+	"winter":    Winter,
+	"easter":    Easter,
+	"christmas": Christmas,
+	"xmas":      Christmas,
+}
+
+// months each season spans, used for ordering. Winter runs into the next year.
+var seasonMonths = map[Season][2]time.Month{
+	Spring:    {time.March, time.May},
+	Summer:    {time.June, time.August},
+	Autumn:    {time.September, time.November},
+	Winter:    {time.December, time.February},
+	Easter:    {time.March, time.April},
+	Christmas: {time.December, time.December},
+}
+
+// DatePoint is a single point in time, known to some precision.
+type DatePoint struct {
+	Year      int
+	Month     time.Month
+	Day       int
+	Season    Season
+	Precision Precision
+}
+
+// DateRange is an inclusive span between two points. A single date has Start == End.
+// Either end may be open, e.g. "2019 - present" or "until 2012".
+type DateRange struct {
+	Start     DatePoint
+	End       DatePoint
+	OpenStart bool
+	OpenEnd   bool
+}
+
+// DateSpec is a parsed memory or song date. The original text is kept in Raw
+// so pages can show it exactly as it was written.
+type DateSpec struct {
+	Raw    string
+	Ranges []DateRange
+	Circa  bool
+}
+
+var (
+	yearRe      = regexp.MustCompile(`^\d{4}$`)
+	isoMonthRe  = regexp.MustCompile(`^(\d{4})-(\d{1,2})$`)
+	isoDayRe    = regexp.MustCompile(`^(\d{4})-(\d{1,2})-(\d{1,2})$`)
+	circaRe     = regexp.MustCompile(`^(?:circa|ca\.?|c\.|~)\s*`)
+	openEndRe   = regexp.MustCompile(`^(?:since|from)\s+(.+)$|^(.+?)\s*(?:onwards|\+)$`)
+	openStartRe = regexp.MustCompile(`^(?:until|before|up to)\s+(.+)$`)
+)
+
+// range separators, tried in order. A bare hyphen is last since it also appears in ISO dates.
+var rangeSeparators = []string{" to ", " until ", "–", "—", " - ", "-"}
+
+var openWords = map[string]bool{"present": true, "now": true, "today": true, "ongoing": true}
+
+// ParseDateSpec parses the free-form dates used in memory files. It understands:
+//
+//	years          "2019"
+//	seasons        "Summer 2024", "Easter 2022"
+//	months         "Nov 2022", "2022-11"
+//	exact days     "2025-01-15", "15 January 2025", "January 15, 2025"
+//	ranges         "2019-2022", "2016 - 2023", "March 2017 to June 2018"
+//	open ranges    "2019 - present", "since 2019", "until 2012"
+//	lists          "2019, 2020, 2022"
+//	approximations "circa 2010", "c. 2010", "~2010"
+//
+// An empty string parses to the zero DateSpec.
+func ParseDateSpec(s string) (DateSpec, error) {
+	spec := DateSpec{Raw: strings.TrimSpace(s)}
+	text := strings.ToLower(spec.Raw)
+	if text == "" {
+		return spec, nil
+	}
+
+	if m := circaRe.FindString(text); m != "" {
+		spec.Circa = true
+		text = strings.TrimSpace(text[len(m):])
+	}
+
+	// A whole-string match wins, so "January 15, 2025" isn't mistaken for a list.
+	if r, ok := parseRange(text); ok {
+		spec.Ranges = []DateRange{r}
+		return spec, nil
+	}
+
+	for _, part := range strings.Split(text, ",") {
+		r, ok := parseRange(strings.TrimSpace(part))
+		if !ok {
+			return DateSpec{}, fmt.Errorf("unrecognised date %q", spec.Raw)
+		}
+		spec.Ranges = append(spec.Ranges, r)
+	}
+	return spec, nil
+}
+
+func parseRange(s string) (DateRange, bool) {
+	if s == "" {
+		return DateRange{}, false
+	}
+	if p, ok := parsePoint(s); ok {
+		return DateRange{Start: p, End: p}, true
+	}
+	if m := openEndRe.FindStringSubmatch(s); m != nil {
+		if p, ok := parsePoint(strings.TrimSpace(m[1] + m[2])); ok {
+			return DateRange{Start: p, End: p, OpenEnd: true}, true
+		}
+	}
+	if m := openStartRe.FindStringSubmatch(s); m != nil {
+		if p, ok := parsePoint(strings.TrimSpace(m[1])); ok {
+			return DateRange{Start: p, End: p, OpenStart: true}, true
+		}
+	}
+
+	for _, sep := range rangeSeparators {
+		for i := strings.Index(s, sep); i >= 0; {
+			left := strings.TrimSpace(s[:i])
+			right := strings.TrimSpace(s[i+len(sep):])
+			if r, ok := joinRange(left, right); ok {
+				return r, true
+			}
+			next := strings.Index(s[i+len(sep):], sep)
+			if next < 0 {
+				break
+			}
+			i += len(sep) + next
+		}
+	}
+	return DateRange{}, false
+}
+
+func joinRange(left, right string) (DateRange, bool) {
+	openEnd := right == "" || openWords[right]
+	openStart := left == ""
+	if openStart && openEnd {
+		return DateRange{}, false
+	}
+
+	if openStart {
+		end, ok := parsePoint(right)
+		if !ok {
+			return DateRange{}, false
+		}
+		return DateRange{Start: end, End: end, OpenStart: true}, true
+	}
+
+	start, ok := parsePoint(left)
+	if openEnd {
+		if !ok {
+			return DateRange{}, false
+		}
+		return DateRange{Start: start, End: start, OpenEnd: true}, true
+	}
+
+	end, endOk := parsePoint(right)
+	if !endOk {
+		return DateRange{}, false
+	}
+	if !ok {
+		// "March - June 2017": borrow the year from the end of the range.
+		start, ok = parsePoint(fmt.Sprintf("%s %d", left, end.Year))
+		if !ok {
+			return DateRange{}, false
+		}
+	}
+	if end.last().Before(start.first()) {
+		return DateRange{}, false
+	}
+	return DateRange{Start: start, End: end}, true
+}
+
+func parsePoint(s string) (DatePoint, bool) {
+	if yearRe.MatchString(s) {
+		year, _ := strconv.Atoi(s)
+		return DatePoint{Year: year, Precision: PrecisionYear}, true
+	}
+	if m := isoDayRe.FindStringSubmatch(s); m != nil {
+		return makeDay(m[1], m[2], m[3])
+	}
+	if m := isoMonthRe.FindStringSubmatch(s); m != nil {
+		year, _ := strconv.Atoi(m[1])
+		month, _ := strconv.Atoi(m[2])
+		if month < 1 || month > 12 {
+			return DatePoint{}, false
+		}
+		return DatePoint{Year: year, Month: time.Month(month), Precision: PrecisionMonth}, true
+	}
+
+	fields := strings.Fields(strings.ReplaceAll(s, ",", " "))
+	switch len(fields) {
+	case 2:
+		// "Nov 2022", "Summer 2024"
+		if !yearRe.MatchString(fields[1]) {
+			return DatePoint{}, false
+		}
+		year, _ := strconv.Atoi(fields[1])
+		if season, ok := seasonNames[fields[0]]; ok {
+			return DatePoint{Year: year, Season: season, Precision: PrecisionSeason}, true
+		}
+		if month, ok := parseMonth(fields[0]); ok {
+			return DatePoint{Year: year, Month: month, Precision: PrecisionMonth}, true
+		}
+	case 3:
+		// "15 January 2025" or "January 15 2025"
+		if !yearRe.MatchString(fields[2]) {
+			return DatePoint{}, false
+		}
+		if _, ok := parseMonth(fields[0]); ok {
+			return makeDay(fields[2], fields[0], trimOrdinal(fields[1]))
+		}
+		return makeDay(fields[2], fields[1], trimOrdinal(fields[0]))
+	}
+	return DatePoint{}, false
+}
+
+func makeDay(yearStr, monthStr, dayStr string) (DatePoint, bool) {
+	year, err := strconv.Atoi(yearStr)
+	if err != nil {
+		return DatePoint{}, false
+	}
+	month, ok := parseMonth(monthStr)
+	if !ok {
+		m, err := strconv.Atoi(monthStr)
+		if err != nil || m < 1 || m > 12 {
+			return DatePoint{}, false
+		}
+		month = time.Month(m)
+	}
+	day, err := strconv.Atoi(dayStr)
+	if err != nil || day < 1 || day > daysIn(year, month) {
+		return DatePoint{}, false
+	}
+	return DatePoint{Year: year, Month: month, Day: day, Precision: PrecisionDay}, true
+}
+
+// parseMonth accepts full month names and any abbreviation of at least three letters.
+func parseMonth(s string) (time.Month, bool) {
+	s = strings.TrimSuffix(s, ".")
+	if len(s) < 3 {
+		return 0, false
+	}
+	for m := time.January; m <= time.December; m++ {
+		if strings.HasPrefix(strings.ToLower(m.String()), s) {
+			return m, true
+		}
+	}
+	return 0, false
+}
+
+func trimOrdinal(s string) string {
+	for _, suffix := range []string{"st", "nd", "rd", "th"} {
+		if strings.HasSuffix(s, suffix) {
+			return strings.TrimSuffix(s, suffix)
+		}
+	}
+	return s
+}
+
+func daysIn(year int, month time.Month) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+// first is the earliest instant the point could refer to.
+func (p DatePoint) first() time.Time {
+	switch p.Precision {
+	case PrecisionDay:
+		return time.Date(p.Year, p.Month, p.Day, 0, 0, 0, 0, time.UTC)
+	case PrecisionMonth:
+		return time.Date(p.Year, p.Month, 1, 0, 0, 0, 0, time.UTC)
+	case PrecisionSeason:
+		return time.Date(p.Year, seasonMonths[p.Season][0], 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return time.Date(p.Year, time.January, 1, 0, 0, 0, 0, time.UTC)
+	}
+}
+
+// last is the latest instant the point could refer to.
+func (p DatePoint) last() time.Time {
+	switch p.Precision {
+	case PrecisionDay:
+		return p.first().AddDate(0, 0, 1).Add(-time.Nanosecond)
+	case PrecisionMonth:
+		return p.first().AddDate(0, 1, 0).Add(-time.Nanosecond)
+	case PrecisionSeason:
+		bounds := seasonMonths[p.Season]
+		year := p.Year
+		if bounds[1] < bounds[0] {
+			year++
+		}
+		return time.Date(year, bounds[1]+1, 1, 0, 0, 0, 0, time.UTC).Add(-time.Nanosecond)
+	default:
+		return time.Date(p.Year+1, time.January, 1, 0, 0, 0, 0, time.UTC).Add(-time.Nanosecond)
+	}
+}
+
+// Years returns every year the range touches, resolving an open end to the current year.
+func (r DateRange) Years() []int {
+	start, end := r.Start.Year, r.End.Year
+	if r.OpenEnd {
+		end = max(end, now().Year())
+	}
+	years := make([]int, 0, end-start+1)
+	for y := start; y <= end; y++ {
+		years = append(years, y)
+	}
+	return years
+}
+
+func (d DateSpec) IsZero() bool {
+	return len(d.Ranges) == 0
+}
+
+// Years returns the sorted, de-duplicated years the spec covers.
+func (d DateSpec) Years() []int {
+	set := map[int]struct{}{}
+	for _, r := range d.Ranges {
+		for _, y := range r.Years() {
+			set[y] = struct{}{}
+		}
+	}
+	years := make([]int, 0, len(set))
+	for y := range set {
+		years = append(years, y)
+	}
+	sort.Ints(years)
+	return years
+}
+
+// Earliest is the earliest instant covered, or the zero time for an empty spec.
+func (d DateSpec) Earliest() time.Time {
+	var earliest time.Time
+	for i, r := range d.Ranges {
+		if t := r.Start.first(); i == 0 || t.Before(earliest) {
+			earliest = t
+		}
+	}
+	return earliest
+}
+
+// Latest is the latest instant covered, or the zero time for an empty spec.
+// Open ranges run up to now.
+func (d DateSpec) Latest() time.Time {
+	var latest time.Time
+	for _, r := range d.Ranges {
+		t := r.End.last()
+		if r.OpenEnd {
+			t = now()
+		}
+		if t.After(latest) {
+			latest = t
+		}
+	}
+	return latest
+}
+
+// Before orders specs most-recent-last, by their latest point then their earliest.
+// Empty specs sort first.
+func (d DateSpec) Before(other DateSpec) bool {
+	if !d.Latest().Equal(other.Latest()) {
+		return d.Latest().Before(other.Latest())
+	}
+	return d.Earliest().Before(other.Earliest())
+}
+
+func (d DateSpec) String() string {
+	return d.Raw
+}
+
+// UnmarshalYAML accepts any scalar, so unquoted years like `date: 2018` work too.
+func (d *DateSpec) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind != yaml.ScalarNode {
+		return fmt.Errorf("line %d: date must be a single value", value.Line)
+	}
+	if value.Tag == "!!null" {
+		*d = DateSpec{}
+		return nil
+	}
+	spec, err := ParseDateSpec(value.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", value.Line, err)
+	}
+	*d = spec
+	return nil
+}
+
+func (d DateSpec) MarshalYAML() (any, error) {
+	return d.Raw, nil
+}
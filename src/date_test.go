@@ -0,0 +1,160 @@
+package sonostalgia
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+func yearPoint(y int) DatePoint {
+	return DatePoint{Year: y, Precision: PrecisionYear}
+}
+
+func seasonPoint(s Season, y int) DatePoint {
+	return DatePoint{Year: y, Season: s, Precision: PrecisionSeason}
+}
+
+func monthPoint(m time.Month, y int) DatePoint {
+	return DatePoint{Year: y, Month: m, Precision: PrecisionMonth}
+}
+
+func dayPoint(d int, m time.Month, y int) DatePoint {
+	return DatePoint{Year: y, Month: m, Day: d, Precision: PrecisionDay}
+}
+
+func single(p DatePoint) DateRange {
+	return DateRange{Start: p, End: p}
+}
+
+func span(start, end DatePoint) DateRange {
+	return DateRange{Start: start, End: end}
+}
+
+func TestParseDateSpec(t *testing.T) {
+	tests := []struct {
+		in     string
+		ranges []DateRange
+		circa  bool
+	}{
+		{in: "", ranges: nil},
+
+		// years, seasons and months
+		{in: "2019", ranges: []DateRange{single(yearPoint(2019))}},
+		{in: "Summer 2024", ranges: []DateRange{single(seasonPoint(Summer, 2024))}},
+		{in: "fall 2020", ranges: []DateRange{single(seasonPoint(Autumn, 2020))}},
+		{in: "Easter 2022", ranges: []DateRange{single(seasonPoint(Easter, 2022))}},
+		{in: "Xmas 2021", ranges: []DateRange{single(seasonPoint(Christmas, 2021))}},
+		{in: "Nov 2022", ranges: []DateRange{single(monthPoint(time.November, 2022))}},
+		{in: "Sept. 2022", ranges: []DateRange{single(monthPoint(time.September, 2022))}},
+		{in: "2022-11", ranges: []DateRange{single(monthPoint(time.November, 2022))}},
+
+		// exact days
+		{in: "2025-01-15", ranges: []DateRange{single(dayPoint(15, time.January, 2025))}},
+		{in: "15 January 2025", ranges: []DateRange{single(dayPoint(15, time.January, 2025))}},
+		{in: "15th Jan 2025", ranges: []DateRange{single(dayPoint(15, time.January, 2025))}},
+		{in: "January 15, 2025", ranges: []DateRange{single(dayPoint(15, time.January, 2025))}},
+		{in: "2024-02-29", ranges: []DateRange{single(dayPoint(29, time.February, 2024))}},
+
+		// ranges
+		{in: "2019-2022", ranges: []DateRange{span(yearPoint(2019), yearPoint(2022))}},
+		{in: "2016 - 2023", ranges: []DateRange{span(yearPoint(2016), yearPoint(2023))}},
+		{in: "2016–2023", ranges: []DateRange{span(yearPoint(2016), yearPoint(2023))}},
+		{in: "March 2017 to June 2018", ranges: []DateRange{span(monthPoint(time.March, 2017), monthPoint(time.June, 2018))}},
+		{in: "March - June 2017", ranges: []DateRange{span(monthPoint(time.March, 2017), monthPoint(time.June, 2017))}},
+		{in: "2019-03 to 2019-05", ranges: []DateRange{span(monthPoint(time.March, 2019), monthPoint(time.May, 2019))}},
+		{in: "Winter 2019 - February 2020", ranges: []DateRange{span(seasonPoint(Winter, 2019), monthPoint(time.February, 2020))}},
+
+		// open ranges
+		{in: "2019 - present", ranges: []DateRange{{Start: yearPoint(2019), End: yearPoint(2019), OpenEnd: true}}},
+		{in: "since 2019", ranges: []DateRange{{Start: yearPoint(2019), End: yearPoint(2019), OpenEnd: true}}},
+		{in: "2019 onwards", ranges: []DateRange{{Start: yearPoint(2019), End: yearPoint(2019), OpenEnd: true}}},
+		{in: "2019+", ranges: []DateRange{{Start: yearPoint(2019), End: yearPoint(2019), OpenEnd: true}}},
+		{in: "until 2012", ranges: []DateRange{{Start: yearPoint(2012), End: yearPoint(2012), OpenStart: true}}},
+		{in: "- June 2012", ranges: []DateRange{{Start: monthPoint(time.June, 2012), End: monthPoint(time.June, 2012), OpenStart: true}}},
+
+		// lists and approximations
+		{in: "2019, 2020, 2022", ranges: []DateRange{single(yearPoint(2019)), single(yearPoint(2020)), single(yearPoint(2022))}},
+		{in: "Summer 2019, 2021 - 2022", ranges: []DateRange{single(seasonPoint(Summer, 2019)), span(yearPoint(2021), yearPoint(2022))}},
+		{in: "circa 2010", ranges: []DateRange{single(yearPoint(2010))}, circa: true},
+		{in: "c. 2010", ranges: []DateRange{single(yearPoint(2010))}, circa: true},
+		{in: "~2010", ranges: []DateRange{single(yearPoint(2010))}, circa: true},
+	}
+	for _, test := range tests {
+		spec, err := ParseDateSpec(test.in)
+		if err != nil {
+			t.Errorf("ParseDateSpec(%q): %v", test.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(spec.Ranges, test.ranges) || spec.Circa != test.circa {
+			t.Errorf("ParseDateSpec(%q) = %+v (circa %t), want %+v (circa %t)", test.in, spec.Ranges, spec.Circa, test.ranges, test.circa)
+		}
+		if spec.Raw != test.in {
+			t.Errorf("ParseDateSpec(%q).Raw = %q", test.in, spec.Raw)
+		}
+	}
+}
+
+func TestParseDateSpecRejects(t *testing.T) {
+	for _, in := range []string{
+		"last summer",
+		"19",
+		"Monsoon 2019",
+		"Ju 2019",
+		"2019-13",
+		"2019-02-30",
+		"2023-02-29",
+		"31 April 2020",
+		"2022 - 2019",
+		"June 2019 to May 2019",
+		"present",
+		"since forever",
+		"2019, someday",
+		"2019,",
+	} {
+		if spec, err := ParseDateSpec(in); err == nil {
+			t.Errorf("ParseDateSpec(%q) = %+v, want an error", in, spec.Ranges)
+		}
+	}
+}
+
+func TestDateSpecYears(t *testing.T) {
+	defer func(saved func() time.Time) { now = saved }(now)
+	now = func() time.Time { return time.Date(2021, time.June, 1, 0, 0, 0, 0, time.UTC) }
+
+	tests := map[string][]int{
+		"":                        {},
+		"Winter 2019":             {2019},
+		"2016 - 2018, 2017":       {2016, 2017, 2018},
+		"2019 - present":          {2019, 2020, 2021},
+		"until 2012":              {2012},
+		"March 2017 to June 2018": {2017, 2018},
+	}
+	for in, want := range tests {
+		spec, err := ParseDateSpec(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := spec.Years(); !slices.Equal(got, want) {
+			t.Errorf("%q covers %v, want %v", in, got, want)
+		}
+	}
+}
+
+func TestDateSpecBefore(t *testing.T) {
+	// Most recent last, by the latest point and then the earliest.
+	ordered := []string{"", "until 2012", "2015", "Spring 2016", "Summer 2016", "2014 - 2016", "December 2016", "Winter 2016"}
+	for i := 1; i < len(ordered); i++ {
+		earlier, err := ParseDateSpec(ordered[i-1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		later, err := ParseDateSpec(ordered[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !earlier.Before(later) || later.Before(earlier) {
+			t.Errorf("%q should sort before %q", ordered[i-1], ordered[i])
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package devserver
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/azoghal/sonostalgia/src/templater"
+)
+
+//go:embed livereload.js
+var liveReloadJS []byte
+
+const (
+	eventsPath   = "/__livereload"
+	pollInterval = 300 * time.Millisecond
+)
+
+// watchedDirs are the parts of the source directory that feed into the site.
+var watchedDirs = []string{"memories", "templates", "assets", "map"}
+
+// Serve builds the site, serves outputDir on addr and rebuilds whenever anything under
+// srcDir changes. Open pages are told to reload over server-sent events, and a failed
+// build is shown as an overlay on the page rather than stopping the server.
+func Serve(ctx context.Context, srcDir, outputDir, addr string) error {
+	s := &server{
+		srcDir:    srcDir,
+		outputDir: outputDir,
+		clients:   map[chan event]struct{}{},
+	}
+
+	s.rebuild()
+	go s.watch(ctx)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(eventsPath, s.handleEvents)
+	mux.HandleFunc("/", s.handleFile)
+
+	httpServer := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		httpServer.Shutdown(shutdownCtx)
+	}()
+
+	fmt.Printf("Serving %s → http://%s\n", outputDir, addr)
+	if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+type event struct {
+	name string
+	data string
+}
+
+type server struct {
+	srcDir    string
+	outputDir string
+
+	mu       sync.Mutex
+	buildErr error
+	clients  map[chan event]struct{}
+}
+
+func (s *server) rebuild() {
+	start := time.Now()
+	err := templater.Run(s.srcDir, s.outputDir, templater.Options{})
+
+	s.mu.Lock()
+	s.buildErr = err
+	s.mu.Unlock()
+
+	if err != nil {
+		log.Printf("build failed: %v", err)
+		s.broadcast(errorEvent(err))
+		return
+	}
+	log.Printf("built in %s", time.Since(start).Round(time.Millisecond))
+	s.broadcast(event{name: "reload"})
+}
+
+// watch polls the source directories, rebuilding when a file is added, removed or modified.
+// Polling keeps this dependency free and behaves the same on every platform.
+func (s *server) watch(ctx context.Context) {
+	last := s.snapshot()
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			current := s.snapshot()
+			if current == last {
+				continue
+			}
+			// Give editors a moment to finish writing before building, unless we're stopping.
+			settle := time.NewTimer(pollInterval)
+			select {
+			case <-ctx.Done():
+				settle.Stop()
+				return
+			case <-settle.C:
+			}
+			last = s.snapshot()
+			s.rebuild()
+		}
+	}
+}
+
+// snapshot summarises the name, size and modification time of every watched file.
+func (s *server) snapshot() string {
+	var b strings.Builder
+	for _, dir := range watchedDirs {
+		root := filepath.Join(s.srcDir, dir)
+		filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			fmt.Fprintf(&b, "%s|%d|%d\n", p, info.Size(), info.ModTime().UnixNano())
+			return nil
+		})
+	}
+	return b.String()
+}
+
+func (s *server) broadcast(e event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for client := range s.clients {
+		select {
+		case client <- e:
+		default:
+			// The client is behind; it will catch up on the next event.
+		}
+	}
+}
+
+func (s *server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	client := make(chan event, 4)
+	s.mu.Lock()
+	s.clients[client] = struct{}{}
+	buildErr := s.buildErr
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.clients, client)
+		s.mu.Unlock()
+	}()
+
+	// Pages opened while the build is broken should show the error straight away.
+	if buildErr != nil {
+		writeEvent(w, errorEvent(buildErr))
+	} else {
+		writeEvent(w, event{name: "ok"})
+	}
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e := <-client:
+			writeEvent(w, e)
+			flusher.Flush()
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, e event) {
+	fmt.Fprintf(w, "event: %s\n", e.name)
+	for _, line := range strings.Split(e.data, "\n") {
+		fmt.Fprintf(w, "data: %s\n", line)
+	}
+	fmt.Fprint(w, "\n")
+}
+
+func errorEvent(err error) event {
+	data, _ := json.Marshal(map[string]string{"message": err.Error()})
+	return event{name: "build-error", data: string(data)}
+}
+
+// handleFile serves the output directory, injecting the live reload script into HTML pages.
+func (s *server) handleFile(w http.ResponseWriter, r *http.Request) {
+	name := path.Clean("/" + r.URL.Path)
+	if strings.HasSuffix(r.URL.Path, "/") {
+		name = path.Join(name, "index.html")
+	}
+	if path.Ext(name) != ".html" {
+		w.Header().Set("Cache-Control", "no-store")
+		http.FileServer(http.Dir(s.outputDir)).ServeHTTP(w, r)
+		return
+	}
+
+	page, err := os.ReadFile(filepath.Join(s.outputDir, filepath.FromSlash(name)))
+	if err != nil {
+		s.mu.Lock()
+		buildErr := s.buildErr
+		s.mu.Unlock()
+		if buildErr == nil {
+			http.NotFound(w, r)
+			return
+		}
+		// Nothing has been built yet; serve an empty page so the overlay has somewhere to go.
+		page = []byte("<!DOCTYPE html><html><head><title>Build failed</title></head><body></body></html>")
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(injectScript(page))
+}
+
+func injectScript(page []byte) []byte {
+	script := append(append([]byte("<script>\n"), liveReloadJS...), []byte("</script>\n")...)
+	i := bytes.LastIndex(page, []byte("</body>"))
+	if i < 0 {
+		return append(page, script...)
+	}
+	out := make([]byte, 0, len(page)+len(script))
+	out = append(out, page[:i]...)
+	out = append(out, script...)
+	return append(out, page[i:]...)
+}
@@ -0,0 +1,39 @@
+package devserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDirectoriesServeTheirIndex(t *testing.T) {
+	out := t.TempDir()
+	for name, page := range map[string]string{
+		"index.html":         "<html><body>home</body></html>",
+		"artists/index.html": "<html><body>artists</body></html>",
+	} {
+		file := filepath.Join(out, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(page), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := &server{outputDir: out, clients: map[chan event]struct{}{}}
+
+	for path, want := range map[string]string{"/": "home", "/artists/": "artists"} {
+		w := httptest.NewRecorder()
+		s.handleFile(w, httptest.NewRequest(http.MethodGet, path, nil))
+		page := w.Body.String()
+		if w.Code != http.StatusOK || !strings.Contains(page, want) {
+			t.Errorf("GET %s: got %d %q, want the %s page", path, w.Code, page, want)
+		}
+		if !strings.Contains(page, string(liveReloadJS)) {
+			t.Errorf("GET %s didn't have the live reload script injected", path)
+		}
+	}
+}
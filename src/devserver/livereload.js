@@ -0,0 +1,37 @@
+// Injected by the development server. Reloads the page after each successful build
+// and shows build errors as an overlay instead of a stale page.
+(function () {
+  const overlayId = '__sonostalgia-build-error';
+
+  function showError(message) {
+    let overlay = document.getElementById(overlayId);
+    if (!overlay) {
+      overlay = document.createElement('div');
+      overlay.id = overlayId;
+      overlay.style.cssText = [
+        'position:fixed', 'inset:0', 'z-index:2147483647', 'overflow:auto',
+        'background:rgba(15,15,15,0.94)', 'color:#f5f5f5', 'padding:2rem',
+        'font:14px/1.5 ui-monospace,SFMono-Regular,Menlo,monospace',
+      ].join(';');
+      document.body.appendChild(overlay);
+    }
+    overlay.innerHTML = '';
+    const title = document.createElement('h2');
+    title.textContent = 'Build failed';
+    title.style.cssText = 'color:#ff6b6b;margin:0 0 1rem;font-size:1.2rem';
+    const pre = document.createElement('pre');
+    pre.textContent = message;
+    pre.style.cssText = 'white-space:pre-wrap;margin:0';
+    overlay.append(title, pre);
+  }
+
+  function clearError() {
+    const overlay = document.getElementById(overlayId);
+    if (overlay) overlay.remove();
+  }
+
+  const events = new EventSource('/__livereload');
+  events.addEventListener('reload', () => location.reload());
+  events.addEventListener('ok', clearError);
+  events.addEventListener('build-error', e => showError(JSON.parse(e.data).message));
+})();
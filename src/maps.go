@@ -0,0 +1,106 @@
+package sonostalgia
+
+import (
+	"fmt"
+	"math"
+)
+
+// Place is somewhere a memory happened.
+type Place struct {
+	Name string  `yaml:"name" json:"name"`
+	Lat  float64 `yaml:"lat"  json:"lat"`
+	Lon  float64 `yaml:"lon"  json:"lon"`
+}
+
+// Map is the map page. Coordinates are in the equirectangular projection used by Project.
+type Map struct {
+	ViewBox      string
+	MarkerRadius float64
+	Land         []string // SVG path data for each country in view, filled in by the templater
+	Markers      []MapMarker
+	Unplaced     []Memory // memories without any places
+}
+
+// MapMarker is a single place in a single memory.
+type MapMarker struct {
+	Memory Memory
+	Place  Place
+	X, Y   float64
+}
+
+// MapScale is the number of map units per degree.
+const MapScale = 10
+
+// Project converts a latitude and longitude into map coordinates, with
+// (0, 0) at the top left of the world and y increasing southwards.
+func Project(lat, lon float64) (x, y float64) {
+	return (lon + 180) * MapScale, (90 - lat) * MapScale
+}
+
+// Bounds is a rectangle in map coordinates.
+type Bounds struct {
+	MinX, MinY, MaxX, MaxY float64
+}
+
+// Intersects reports whether the two rectangles overlap.
+func (b Bounds) Intersects(o Bounds) bool {
+	return b.MinX <= o.MaxX && o.MinX <= b.MaxX && b.MinY <= o.MaxY && o.MinY <= b.MaxY
+}
+
+var worldBounds = Bounds{MaxX: 360 * MapScale, MaxY: 180 * MapScale}
+
+// ViewBounds parses the map's ViewBox back into bounds.
+func (m Map) ViewBounds() Bounds {
+	var b Bounds
+	if _, err := fmt.Sscanf(m.ViewBox, "%g %g %g %g", &b.MinX, &b.MinY, &b.MaxX, &b.MaxY); err != nil {
+		return worldBounds
+	}
+	b.MaxX += b.MinX
+	b.MaxY += b.MinY
+	return b
+}
+
+// buildMap places a marker for every place in every memory and frames the view around them.
+func buildMap(memories []Memory) Map {
+	m := Map{}
+	bounds := Bounds{MinX: math.Inf(1), MinY: math.Inf(1), MaxX: math.Inf(-1), MaxY: math.Inf(-1)}
+
+	for _, memory := range memories {
+		if len(memory.Places) == 0 {
+			m.Unplaced = append(m.Unplaced, memory)
+			continue
+		}
+		for _, place := range memory.Places {
+			x, y := Project(place.Lat, place.Lon)
+			m.Markers = append(m.Markers, MapMarker{Memory: memory, Place: place, X: x, Y: y})
+			bounds = Bounds{
+				MinX: math.Min(bounds.MinX, x), MinY: math.Min(bounds.MinY, y),
+				MaxX: math.Max(bounds.MaxX, x), MaxY: math.Max(bounds.MaxY, y),
+			}
+		}
+	}
+
+	view := worldBounds
+	if len(m.Markers) > 0 {
+		view = frame(bounds)
+	}
+	m.ViewBox = fmt.Sprintf("%.1f %.1f %.1f %.1f", view.MinX, view.MinY, view.MaxX-view.MinX, view.MaxY-view.MinY)
+	m.MarkerRadius = math.Round((view.MaxX-view.MinX)/150*10) / 10
+	return m
+}
+
+// frame pads bounds so markers aren't on the edge, keeps a 2:1 aspect ratio and
+// never zooms in closer than minSpan degrees across.
+func frame(b Bounds) Bounds {
+	const minSpan = 20 * MapScale
+
+	width := math.Max((b.MaxX-b.MinX)*1.3, minSpan)
+	height := math.Max((b.MaxY-b.MinY)*1.3, width/2)
+	width = math.Max(width, height*2)
+	width, height = math.Min(width, worldBounds.MaxX), math.Min(height, worldBounds.MaxY)
+
+	cx, cy := (b.MinX+b.MaxX)/2, (b.MinY+b.MaxY)/2
+	minX := math.Min(math.Max(cx-width/2, 0), worldBounds.MaxX-width)
+	minY := math.Min(math.Max(cy-height/2, 0), worldBounds.MaxY-height)
+	return Bounds{MinX: minX, MinY: minY, MaxX: minX + width, MaxY: minY + height}
+}
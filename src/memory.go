@@ -1,36 +1,84 @@
 package sonostalgia
 
 import (
+	"cmp"
 	"fmt"
 	"os"
+	"slices"
 	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
 type Memory struct {
-	OutputTitle string `yaml:"outputTitle"` // filename
-	PageTitle   string `yaml:"shortTitle"`
-	Title       string `yaml:"title"`
-	Subtitle    string `yaml:"subtitle"`
-	Date        string `yaml:"date"`
-	Songs       []Song `yaml:"songs"`
-	Content     string `yaml:"content"` // load strings from file, we convert markdown to html in the template
-	OtherSongs  []Song `yaml:"otherSongs"`
+	OutputTitle string    `yaml:"outputTitle"` // filename
+	PageTitle   string    `yaml:"shortTitle"`
+	Title       string    `yaml:"title"`
+	Subtitle    string    `yaml:"subtitle"`
+	Date        DateSpec  `yaml:"date"`
+	Published   time.Time `yaml:"published,omitempty"` // when the memory was first saved, for feeds
+	Songs       []Song    `yaml:"songs"`
+	Content     string    `yaml:"content"` // load strings from file, we convert markdown to html in the template
+	OtherSongs  []Song    `yaml:"otherSongs"`
+	Tags        []string  `yaml:"tags,omitempty"`   // e.g. "road trip", "university"
+	People      []string  `yaml:"people,omitempty"` // who was there
+	Places      []Place   `yaml:"places,omitempty"`
+	Palette     *Palette  `yaml:"palette,omitempty"`  // overrides colours taken from the song covers
+	Author      string    `yaml:"author,omitempty"`   // who first saved the memory in the creator
+	EditedBy    string    `yaml:"editedBy,omitempty"` // who saved it in the creator last
 }
 
 type Song struct {
 	Name         string   `yaml:"name"`
 	SongLink     string   `yaml:"link"`
 	Artists      []Artist `yaml:"artists"`
-	RelevantDate string   `yaml:"relevantDate"` // string as it's free-form
+	RelevantDate DateSpec `yaml:"relevantDate"`
 	ImageLink    string   `yaml:"imageLink"`
+	Slug         string   `yaml:"-" json:"-"` // set by LoadSonostalgia, names the song's page
+	Palette      *Palette `yaml:"-" json:"-"` // set by the templater from the song's cover image
 	// SpotifyId string - could use this to populate the above for each song rather than having to manaully find them all
 }
 
 type Artist struct {
 	Name string
 	Link string
+	Slug string `yaml:"-" json:"-"` // set by LoadSonostalgia, names the artist's page
+}
+
+// Palette is a pair of colours to tint a memory's page and card with, as CSS hex colours like #1a2b3c.
+type Palette struct {
+	Dominant string `yaml:"dominant,omitempty"` // the most common colour
+	Accent   string `yaml:"accent,omitempty"`   // a colour that stands out against the dominant one
+}
+
+// Theme is the palette a memory is shown in. Colours set on the memory win, and the rest
+// come from the first of its songs with a cover. It's nil if there are no colours at all.
+func (m Memory) Theme() *Palette {
+	var theme Palette
+	for _, song := range slices.Concat(m.Songs, m.OtherSongs) {
+		if song.Palette != nil {
+			theme = *song.Palette
+			break
+		}
+	}
+	if m.Palette != nil {
+		theme.Dominant = cmp.Or(m.Palette.Dominant, theme.Dominant)
+		theme.Accent = cmp.Or(m.Palette.Accent, theme.Accent)
+	}
+	if theme == (Palette{}) {
+		return nil
+	}
+	return &theme
+}
+
+// PublishedTime is when the memory was first saved. Memories written before that was
+// recorded fall back to the start of their date.
+func (m Memory) PublishedTime() time.Time {
+	if !m.Published.IsZero() {
+		return m.Published.UTC()
+	}
+	return m.Date.Earliest()
 }
 
 func LoadMemory(filename string) (*Memory, error) {
@@ -38,13 +86,15 @@ func LoadMemory(filename string) (*Memory, error) {
 	if err != nil {
 		return nil, err
 	}
+	return ParseMemory(data)
+}
 
+// ParseMemory reads a memory from the contents of a memory file.
+func ParseMemory(data []byte) (*Memory, error) {
 	var memory Memory
-	err = yaml.Unmarshal(data, &memory)
-	if err != nil {
+	if err := yaml.Unmarshal(data, &memory); err != nil {
 		return nil, err
 	}
-
 	return &memory, nil
 }
 
@@ -0,0 +1,27 @@
+// Package fakecover generates stand-in cover art for the fake metadata servers.
+package fakecover
+
+import (
+	"bytes"
+	"hash/fnv"
+	"image"
+	"image/jpeg"
+)
+
+// JPEG is a square image width pixels wide, filled with a colour derived from key
+// so that different albums get visibly different covers.
+func JPEG(key string, width int) ([]byte, error) {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	sum := h.Sum32()
+
+	img := image.NewRGBA(image.Rect(0, 0, width, width))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = uint8(sum), uint8(sum>>8), uint8(sum>>16), 255
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,106 @@
+// Package metadata looks up songs, their artists and cover art from music services.
+package metadata
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"unicode"
+
+	sonostalgia "github.com/azoghal/sonostalgia/src"
+)
+
+const (
+	minDesiredWidth = 100 // if we can, make sure all images are at least 100px width/height
+	maxDesiredWidth = 350 // if we can, try to keep the images a reasonable size
+)
+
+// Track is what a provider knows about a song.
+type Track struct {
+	ID        string
+	Name      string
+	Link      string
+	Artists   []sonostalgia.Artist
+	AlbumID   string
+	AlbumName string
+	ImageURL  string // the best cover art found with the track, empty if there is none
+}
+
+// MetadataProvider is a music service that songs can be looked up in.
+type MetadataProvider interface {
+	// Search returns up to limit tracks matching a free text query.
+	Search(ctx context.Context, query string, limit int) ([]Track, error)
+	// GetTrack looks up a single track by the provider's ID for it, or a link to it on the provider's site.
+	GetTrack(ctx context.Context, id string) (*Track, error)
+	// GetAlbumArt returns the URL of the best cover image for an album, or "" if it has none.
+	GetAlbumArt(ctx context.Context, albumID string) (string, error)
+}
+
+// Provider names, as chosen in the creator and songfetcher.
+const (
+	SpotifyProvider     = "spotify"
+	MusicBrainzProvider = "musicbrainz"
+)
+
+// Image is a candidate cover image.
+type Image struct {
+	URL   string
+	Width int
+}
+
+// BestImage picks the image which matches the most size constraints, or "" if there are none.
+func BestImage(images []Image) string {
+	best := ""
+	bestScore := 0
+	for _, img := range images {
+		score := 1
+		if img.Width < maxDesiredWidth {
+			score++
+		}
+		if img.Width > minDesiredWidth {
+			score++
+		}
+		if score > bestScore {
+			best = img.URL
+			bestScore = score
+		}
+	}
+	return best
+}
+
+// ImageName turns a track name into a file name for its cover art, e.g. "Bed Chem" => "bed-chem".
+func ImageName(trackName string) string {
+	alpha := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsNumber(r) || unicode.IsSpace(r) {
+			return r
+		}
+		return -1
+	}, trackName)
+	return strings.Join(strings.Fields(strings.ToLower(alpha)), "-")
+}
+
+// DownloadImage saves the image at url to filePath.
+func DownloadImage(url, filePath string) error {
+	resp, err := http.Get(url)
+	if err != nil {
+		return fmt.Errorf("failed to fetch URL: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("bad status: %s", resp.Status)
+	}
+
+	f, err := os.Create(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+package metadata
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	sonostalgia "github.com/azoghal/sonostalgia/src"
+)
+
+const (
+	musicBrainzURL = "https://musicbrainz.org"
+	coverArtURL    = "https://coverartarchive.org"
+
+	// MusicBrainz asks that clients identify themselves and make no more than one request a second.
+	defaultUserAgent       = "sonostalgia/1.0 ( https://github.com/azoghal/sonostalgia )"
+	defaultRequestInterval = time.Second
+)
+
+var mbidRe = regexp.MustCompile(`[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}`)
+
+// MusicBrainzConfig holds what's needed to talk to MusicBrainz and the Cover Art Archive.
+type MusicBrainzConfig struct {
+	// BaseURL serves the MusicBrainz web service (under /ws/2/), and CoverArtURL the
+	// Cover Art Archive, for pointing at a stand-in like musicbrainzfake. Empty means the real ones.
+	BaseURL     string
+	CoverArtURL string
+	UserAgent   string        // sent with every request, defaultUserAgent if empty
+	Interval    time.Duration // the least time between requests to MusicBrainz, a second if zero
+}
+
+// MusicBrainz resolves tracks to MusicBrainz recordings, and albums to releases whose
+// cover art comes from the Cover Art Archive. Links point at musicbrainz.org.
+type MusicBrainz struct {
+	client      *http.Client
+	baseURL     string
+	coverArtURL string
+	userAgent   string
+	interval    time.Duration
+
+	mu   sync.Mutex
+	last time.Time
+}
+
+var _ MetadataProvider = (*MusicBrainz)(nil)
+
+func NewMusicBrainz(config MusicBrainzConfig) *MusicBrainz {
+	m := &MusicBrainz{
+		client:      &http.Client{Timeout: 20 * time.Second},
+		baseURL:     musicBrainzURL,
+		coverArtURL: coverArtURL,
+		userAgent:   defaultUserAgent,
+		interval:    defaultRequestInterval,
+	}
+	if config.BaseURL != "" {
+		m.baseURL = strings.TrimSuffix(config.BaseURL, "/")
+	}
+	if config.CoverArtURL != "" {
+		m.coverArtURL = strings.TrimSuffix(config.CoverArtURL, "/")
+	}
+	if config.UserAgent != "" {
+		m.userAgent = config.UserAgent
+	}
+	if config.Interval != 0 {
+		m.interval = config.Interval
+	}
+	return m
+}
+
+type mbRecording struct {
+	ID           string `json:"id"`
+	Title        string `json:"title"`
+	ArtistCredit []struct {
+		Name   string `json:"name"`
+		Artist struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"artist"`
+	} `json:"artist-credit"`
+	Releases []struct {
+		ID     string `json:"id"`
+		Title  string `json:"title"`
+		Status string `json:"status"`
+	} `json:"releases"`
+}
+
+type coverArt struct {
+	Images []struct {
+		Front      bool              `json:"front"`
+		Image      string            `json:"image"`
+		Thumbnails map[string]string `json:"thumbnails"`
+	} `json:"images"`
+}
+
+func (m *MusicBrainz) Search(ctx context.Context, query string, limit int) ([]Track, error) {
+	params := url.Values{"query": {query}, "limit": {strconv.Itoa(limit)}, "fmt": {"json"}}
+	var res struct {
+		Recordings []mbRecording `json:"recordings"`
+	}
+	if err := m.get(ctx, m.baseURL+"/ws/2/recording?"+params.Encode(), true, &res); err != nil {
+		return nil, err
+	}
+	tracks := make([]Track, 0, len(res.Recordings))
+	for _, recording := range res.Recordings {
+		track := m.track(recording)
+		// Looking up art for every result would take a request each; the archive's
+		// front cover redirect is good enough for a preview and to download.
+		if track.AlbumID != "" {
+			track.ImageURL = fmt.Sprintf("%s/release/%s/front-250", m.coverArtURL, track.AlbumID)
+		}
+		tracks = append(tracks, track)
+	}
+	return tracks, nil
+}
+
+func (m *MusicBrainz) GetTrack(ctx context.Context, id string) (*Track, error) {
+	mbid := mbidRe.FindString(strings.ToLower(id))
+	if mbid == "" {
+		return nil, fmt.Errorf("track lookup failed: %q isn't a MusicBrainz recording ID", id)
+	}
+	params := url.Values{"inc": {"artists releases"}, "fmt": {"json"}}
+	var recording mbRecording
+	if err := m.get(ctx, m.baseURL+"/ws/2/recording/"+mbid+"?"+params.Encode(), true, &recording); err != nil {
+		return nil, fmt.Errorf("track lookup failed: %w", err)
+	}
+	track := m.track(recording)
+	return &track, nil
+}
+
+// GetAlbumArt returns the release's front cover, or "" if the archive has no art for it.
+func (m *MusicBrainz) GetAlbumArt(ctx context.Context, albumID string) (string, error) {
+	if albumID == "" {
+		return "", nil
+	}
+	var art coverArt
+	err := m.get(ctx, m.coverArtURL+"/release/"+albumID, false, &art)
+	if errors.Is(err, errNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("album art lookup failed: %w", err)
+	}
+	for _, image := range art.Images {
+		if !image.Front {
+			continue
+		}
+		var candidates []Image
+		for size, link := range image.Thumbnails {
+			if width, err := strconv.Atoi(size); err == nil {
+				candidates = append(candidates, Image{URL: link, Width: width})
+			}
+		}
+		if best := BestImage(candidates); best != "" {
+			return best, nil
+		}
+		return image.Image, nil
+	}
+	return "", nil
+}
+
+// track prefers an official release for the album, as bootlegs and compilations rarely have art.
+func (m *MusicBrainz) track(recording mbRecording) Track {
+	artists := make([]sonostalgia.Artist, len(recording.ArtistCredit))
+	for i, credit := range recording.ArtistCredit {
+		artists[i] = sonostalgia.Artist{Name: credit.Name, Link: musicBrainzURL + "/artist/" + credit.Artist.ID}
+	}
+	track := Track{
+		ID:      recording.ID,
+		Name:    recording.Title,
+		Link:    musicBrainzURL + "/recording/" + recording.ID,
+		Artists: artists,
+	}
+	for i, release := range recording.Releases {
+		if i == 0 || release.Status == "Official" {
+			track.AlbumID = release.ID
+			track.AlbumName = release.Title
+		}
+		if release.Status == "Official" {
+			break
+		}
+	}
+	return track
+}
+
+var errNotFound = errors.New("not found")
+
+// get fetches a JSON document, waiting first if it's a MusicBrainz request made too soon after the last.
+func (m *MusicBrainz) get(ctx context.Context, link string, throttle bool, out any) error {
+	if throttle {
+		if err := m.wait(ctx); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", m.userAgent)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return errNotFound
+	case resp.StatusCode != http.StatusOK:
+		return fmt.Errorf("bad status: %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (m *MusicBrainz) wait(ctx context.Context) error {
+	m.mu.Lock()
+	next := m.last.Add(m.interval)
+	now := time.Now()
+	if next.Before(now) {
+		next = now
+	}
+	m.last = next
+	m.mu.Unlock()
+
+	select {
+	case <-time.After(time.Until(next)):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
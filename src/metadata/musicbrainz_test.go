@@ -0,0 +1,121 @@
+package metadata
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/azoghal/sonostalgia/src/metadata/musicbrainzfake"
+)
+
+const (
+	junoID        = "0f6c2a4e-3b1d-4c8e-9a57-2d1e8b6f4c31"
+	shortNSweetID = "5a1c9e2b-7d4f-4e6a-b8c3-1f2e3d4c5b6a"
+	charmID       = "e1f2a3b4-c5d6-4e7f-8a9b-0c1d2e3f4a5b"
+)
+
+func newFakeMusicBrainz(t *testing.T) (*MusicBrainz, *musicbrainzfake.Server, string) {
+	t.Helper()
+	fake := musicbrainzfake.New()
+	srv := httptest.NewServer(fake)
+	t.Cleanup(func() {
+		srv.Close()
+		if misses := fake.Misses(); len(misses) > 0 {
+			t.Errorf("requests with no recorded response: %v", misses)
+		}
+	})
+	mb := NewMusicBrainz(MusicBrainzConfig{BaseURL: srv.URL, CoverArtURL: srv.URL, Interval: time.Nanosecond})
+	return mb, fake, srv.URL
+}
+
+func TestMusicBrainzSearch(t *testing.T) {
+	mb, _, base := newFakeMusicBrainz(t)
+
+	tracks, err := mb.Search(context.Background(), "juno", 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tracks) != 2 {
+		t.Fatalf("found %d tracks, want 2", len(tracks))
+	}
+
+	juno := tracks[0]
+	if juno.ID != junoID || juno.Name != "Juno" || juno.Link != "https://musicbrainz.org/recording/"+junoID {
+		t.Errorf("first result = %+v", juno)
+	}
+	if len(juno.Artists) != 1 || juno.Artists[0].Name != "Sabrina Carpenter" || juno.Artists[0].Link != "https://musicbrainz.org/artist/1882fe91-cdd9-49c9-9956-8e06a3810bd4" {
+		t.Errorf("artists = %+v", juno.Artists)
+	}
+	// The bootleg is listed first, but the official release is the one with art.
+	if juno.AlbumID != shortNSweetID || juno.AlbumName != "Short n’ Sweet" {
+		t.Errorf("album = %s %q, want the official release", juno.AlbumID, juno.AlbumName)
+	}
+	if want := base + "/release/" + shortNSweetID + "/front-250"; juno.ImageURL != want {
+		t.Errorf("imageURL = %q, want %q", juno.ImageURL, want)
+	}
+
+	if demo := tracks[1]; demo.AlbumID != "" || demo.ImageURL != "" {
+		t.Errorf("a recording with no releases has album %q and image %q", demo.AlbumID, demo.ImageURL)
+	}
+}
+
+func TestMusicBrainzGetTrack(t *testing.T) {
+	mb, _, _ := newFakeMusicBrainz(t)
+
+	for _, id := range []string{
+		junoID,
+		"https://musicbrainz.org/recording/" + junoID,
+		" " + strings.ToUpper(junoID) + " ",
+	} {
+		track, err := mb.GetTrack(context.Background(), id)
+		if err != nil {
+			t.Errorf("GetTrack(%q): %v", id, err)
+			continue
+		}
+		if track.Name != "Juno" || track.AlbumID != shortNSweetID {
+			t.Errorf("GetTrack(%q) = %+v", id, track)
+		}
+	}
+
+	if _, err := mb.GetTrack(context.Background(), "not-an-id"); err == nil {
+		t.Error("GetTrack accepted something that isn't an MBID")
+	}
+}
+
+func TestMusicBrainzGetAlbumArt(t *testing.T) {
+	mb, _, base := newFakeMusicBrainz(t)
+
+	art, err := mb.GetAlbumArt(context.Background(), shortNSweetID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The 250px front thumbnail best fits the size we're after; the back cover is ignored.
+	if want := base + "/release/" + shortNSweetID + "/40123456789-250.jpg"; art != want {
+		t.Errorf("art = %q, want %q", art, want)
+	}
+
+	// The archive has no art for Charm, which isn't an error.
+	art, err = mb.GetAlbumArt(context.Background(), charmID)
+	if err != nil || art != "" {
+		t.Errorf("art for a release without any = %q, %v; want none", art, err)
+	}
+}
+
+func TestMusicBrainzThrottles(t *testing.T) {
+	fake := musicbrainzfake.New()
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	mb := NewMusicBrainz(MusicBrainzConfig{BaseURL: srv.URL, CoverArtURL: srv.URL, Interval: 50 * time.Millisecond})
+
+	start := time.Now()
+	for range 3 {
+		if _, err := mb.GetTrack(context.Background(), junoID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("3 requests took %s, want at least 100ms between them", elapsed)
+	}
+}
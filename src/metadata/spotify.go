@@ -0,0 +1,123 @@
+package metadata
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	sonostalgia "github.com/azoghal/sonostalgia/src"
+	spotify "github.com/zmb3/spotify/v2"
+	spotifyauth "github.com/zmb3/spotify/v2/auth"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// DefaultMarket is used when no market is configured. Availability and
+// track links differ between markets, so lookups are always made in one.
+const DefaultMarket = "GB"
+
+// SpotifyConfig holds what's needed to talk to the Spotify Web API.
+type SpotifyConfig struct {
+	ClientID     string
+	ClientSecret string
+	Market       string // ISO 3166-1 alpha-2 country code, DefaultMarket if empty
+	// BaseURL serves both the Web API (under /v1/) and the token endpoint (/api/token),
+	// for pointing at a stand-in like spotifyfake. Empty means the real Spotify.
+	BaseURL string
+}
+
+var spotifyURIRe = regexp.MustCompile(`^spotify:track:([A-Za-z0-9]+)$`)
+
+// Spotify looks tracks up with the Spotify Web API, authenticating with client credentials.
+type Spotify struct {
+	client *spotify.Client
+	market string
+}
+
+var _ MetadataProvider = (*Spotify)(nil)
+
+func NewSpotify(ctx context.Context, config SpotifyConfig) *Spotify {
+	credentials := &clientcredentials.Config{
+		ClientID:     config.ClientID,
+		ClientSecret: config.ClientSecret,
+		TokenURL:     spotifyauth.TokenURL,
+	}
+	var opts []spotify.ClientOption
+	if config.BaseURL != "" {
+		base := strings.TrimSuffix(config.BaseURL, "/")
+		credentials.TokenURL = base + "/api/token"
+		opts = append(opts, spotify.WithBaseURL(base+"/v1/"))
+	}
+	market := config.Market
+	if market == "" {
+		market = DefaultMarket
+	}
+	return &Spotify{
+		client: spotify.New(credentials.Client(ctx), opts...),
+		market: market,
+	}
+}
+
+func (s *Spotify) Search(ctx context.Context, query string, limit int) ([]Track, error) {
+	res, err := s.client.Search(ctx, query, spotify.SearchTypeTrack, spotify.Limit(limit), spotify.Market(s.market))
+	if err != nil {
+		return nil, err
+	}
+	tracks := make([]Track, 0, len(res.Tracks.Tracks))
+	for _, t := range res.Tracks.Tracks {
+		tracks = append(tracks, spotifyTrack(t))
+	}
+	return tracks, nil
+}
+
+func (s *Spotify) GetTrack(ctx context.Context, id string) (*Track, error) {
+	t, err := s.client.GetTrack(ctx, spotify.ID(spotifyTrackID(id)), spotify.Market(s.market))
+	if err != nil {
+		return nil, fmt.Errorf("track lookup failed: %w", err)
+	}
+	track := spotifyTrack(*t)
+	return &track, nil
+}
+
+func (s *Spotify) GetAlbumArt(ctx context.Context, albumID string) (string, error) {
+	album, err := s.client.GetAlbum(ctx, spotify.ID(albumID), spotify.Market(s.market))
+	if err != nil {
+		return "", fmt.Errorf("album lookup failed: %w", err)
+	}
+	return BestImage(spotifyImages(album.Images)), nil
+}
+
+// spotifyTrackID accepts a track link, a spotify:track: URI or a bare ID.
+func spotifyTrackID(s string) string {
+	if id := sonostalgia.SpotifyLinkTrackID(s); id != "" {
+		return id
+	}
+	if m := spotifyURIRe.FindStringSubmatch(s); m != nil {
+		return m[1]
+	}
+	return strings.TrimSpace(s)
+}
+
+func spotifyTrack(t spotify.FullTrack) Track {
+	artists := make([]sonostalgia.Artist, len(t.Artists))
+	for i, a := range t.Artists {
+		artists[i] = sonostalgia.Artist{Name: a.Name, Link: a.ExternalURLs["spotify"]}
+	}
+	return Track{
+		ID:        t.ID.String(),
+		Name:      t.Name,
+		Link:      t.ExternalURLs["spotify"],
+		Artists:   artists,
+		AlbumID:   t.Album.ID.String(),
+		AlbumName: t.Album.Name,
+		ImageURL:  BestImage(spotifyImages(t.Album.Images)),
+	}
+}
+
+func spotifyImages(images []spotify.Image) []Image {
+	out := make([]Image, len(images))
+	for i, img := range images {
+		out[i] = Image{URL: img.URL, Width: int(img.Width)}
+	}
+	return out
+}
@@ -0,0 +1,224 @@
+// Package spotifyfake is a stand-in for the parts of the Spotify Web API that sonostalgia
+// uses: the client credentials token endpoint, track search, and track and album lookup.
+// It serves the tracks in fixtures.json and generates their cover art, so the creator and
+// songfetcher can be run and tested without credentials or a network connection.
+package spotifyfake
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	spotify "github.com/zmb3/spotify/v2"
+
+	"github.com/azoghal/sonostalgia/src/metadata/internal/fakecover"
+)
+
+//go:embed fixtures.json
+var fixturesJSON []byte
+
+const accessToken = "spotifyfake-token"
+
+var imagePathRe = regexp.MustCompile(`^/images/([A-Za-z0-9]+)-(\d+)\.jpg$`)
+
+// Server answers Spotify API requests from fixtures. Point a metadata.SpotifyConfig's
+// BaseURL at wherever it is served.
+type Server struct {
+	tracks []spotify.FullTrack
+	albums map[string]spotify.FullAlbum
+
+	requests atomic.Int64
+}
+
+type fixtures struct {
+	Albums []spotify.FullAlbum `json:"albums"`
+	Tracks []spotify.FullTrack `json:"tracks"`
+}
+
+// New loads the fixtures. Each track's album is filled in from the album fixtures, as the real API does.
+func New() *Server {
+	var f fixtures
+	if err := json.Unmarshal(fixturesJSON, &f); err != nil {
+		panic(fmt.Sprintf("spotifyfake: bad fixtures: %v", err))
+	}
+	s := &Server{albums: map[string]spotify.FullAlbum{}}
+	for _, album := range f.Albums {
+		s.albums[album.ID.String()] = album
+	}
+	for _, track := range f.Tracks {
+		album, ok := s.albums[track.Album.ID.String()]
+		if !ok {
+			panic(fmt.Sprintf("spotifyfake: track %s is on unknown album %s", track.ID, track.Album.ID))
+		}
+		track.Album = album.SimpleAlbum
+		s.tracks = append(s.tracks, track)
+	}
+	return s
+}
+
+// Requests is the number of API requests served, not counting tokens and images.
+func (s *Server) Requests() int64 {
+	return s.requests.Load()
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/api/token":
+		s.handleToken(w, r)
+	case strings.HasPrefix(r.URL.Path, "/images/"):
+		s.handleImage(w, r)
+	case strings.HasPrefix(r.URL.Path, "/v1/"):
+		if r.Header.Get("Authorization") != "Bearer "+accessToken {
+			writeError(w, http.StatusUnauthorized, "No token provided")
+			return
+		}
+		s.requests.Add(1)
+		s.handleAPI(w, r, strings.TrimPrefix(r.URL.Path, "/v1/"))
+	default:
+		writeError(w, http.StatusNotFound, "Service not found")
+	}
+}
+
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// Credentials may come as basic auth or in the form; any will do, but some must be given.
+	id, _, ok := r.BasicAuth()
+	if !ok {
+		id = r.FormValue("client_id")
+	}
+	if id == "" || r.FormValue("grant_type") != "client_credentials" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+	})
+}
+
+func (s *Server) handleAPI(w http.ResponseWriter, r *http.Request, path string) {
+	resource, id, _ := strings.Cut(path, "/")
+	switch {
+	case resource == "search" && id == "":
+		s.handleSearch(w, r)
+	case resource == "tracks" && id != "":
+		for _, track := range s.tracks {
+			if track.ID.String() == id {
+				writeJSON(w, r, track)
+				return
+			}
+		}
+		writeError(w, http.StatusNotFound, "non existing id")
+	case resource == "albums" && id != "":
+		album, ok := s.albums[id]
+		if !ok {
+			writeError(w, http.StatusNotFound, "non existing id")
+			return
+		}
+		writeJSON(w, r, album)
+	default:
+		writeError(w, http.StatusNotFound, "Service not found")
+	}
+}
+
+// handleSearch matches tracks whose name or artists contain every word of the query.
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("type") != "track" {
+		writeError(w, http.StatusBadRequest, "only track searches are supported")
+		return
+	}
+	limit := 20
+	if l, err := strconv.Atoi(query.Get("limit")); err == nil && l > 0 {
+		limit = l
+	}
+
+	words := strings.Fields(strings.ToLower(query.Get("q")))
+	items := []spotify.FullTrack{}
+	for _, track := range s.tracks {
+		if len(items) == limit {
+			break
+		}
+		text := strings.ToLower(track.Name)
+		for _, artist := range track.Artists {
+			text += " " + strings.ToLower(artist.Name)
+		}
+		matches := len(words) > 0
+		for _, word := range words {
+			matches = matches && strings.Contains(text, word)
+		}
+		if matches {
+			items = append(items, track)
+		}
+	}
+
+	writeJSON(w, r, map[string]any{
+		"tracks": map[string]any{
+			"href":   r.URL.String(),
+			"items":  items,
+			"limit":  limit,
+			"offset": 0,
+			"total":  len(items),
+		},
+	})
+}
+
+// handleImage generates a square cover of the requested width, coloured by album.
+func (s *Server) handleImage(w http.ResponseWriter, r *http.Request) {
+	m := imagePathRe.FindStringSubmatch(r.URL.Path)
+	if m == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if _, ok := s.albums[m[1]]; !ok {
+		http.NotFound(w, r)
+		return
+	}
+	width, _ := strconv.Atoi(m[2])
+	if width <= 0 || width > 2000 {
+		http.NotFound(w, r)
+		return
+	}
+
+	cover, err := fakecover.JPEG(m[1], width)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Write(cover)
+}
+
+// writeJSON encodes v, turning the fixtures' root-relative image paths into URLs on this server.
+func writeJSON(w http.ResponseWriter, r *http.Request, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	data = bytes.ReplaceAll(data, []byte(`"url":"/images/`), []byte(`"url":"http://`+r.Host+`/images/`))
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// writeError responds in the shape of a Spotify API error, which the client library decodes.
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{
+		"error": map[string]any{"status": status, "message": message},
+	})
+}
@@ -0,0 +1,140 @@
+package sonostalgia
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var spotifyTrackRe = regexp.MustCompile(`open\.spotify\.com/(?:intl-[a-z]+/)?track/([A-Za-z0-9]+)`)
+
+// SongPage gathers every memory a single song appears in.
+type SongPage struct {
+	Song        Song // the first appearance, used for the name, artists and links
+	ImageLink   string
+	Appearances []Appearance
+	Memories    []Memory
+}
+
+// Appearance is a song as listed in a particular memory.
+type Appearance struct {
+	Song   Song
+	Memory Memory
+	Other  bool // listed under the memory's otherSongs
+}
+
+// SpotifyTrackID returns the track ID from the song's Spotify link, or "" if it doesn't have one.
+func (s Song) SpotifyTrackID() string {
+	return SpotifyLinkTrackID(s.SongLink)
+}
+
+// SpotifyLinkTrackID returns the track ID from a link to a track on open.spotify.com, or ""
+// if link isn't one.
+func SpotifyLinkTrackID(link string) string {
+	if m := spotifyTrackRe.FindStringSubmatch(link); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// Key identifies a song regardless of which memory it's listed in. The Spotify track ID is
+// used where there is one, otherwise the normalised name and every artist.
+func (s Song) Key() string {
+	if id := s.SpotifyTrackID(); id != "" {
+		return "spotify:" + id
+	}
+	artists := make([]string, len(s.Artists))
+	for i, artist := range s.Artists {
+		artists[i] = normalise(artist.Name)
+	}
+	sort.Strings(artists)
+	return fmt.Sprintf("song:%s|%s", normalise(s.Name), strings.Join(artists, "|"))
+}
+
+func normalise(s string) string {
+	return strings.Join(strings.Fields(strings.ToLower(s)), " ")
+}
+
+// assignSongSlugs gives every distinct song a unique slug, storing it on each Song in place.
+// Slugs are allocated in key order so that they are stable between builds.
+func assignSongSlugs(memories []Memory) {
+	names := map[string]Song{}
+	forEachSong(memories, func(_ Memory, song Song, _ bool) {
+		if _, ok := names[song.Key()]; !ok {
+			names[song.Key()] = song
+		}
+	})
+
+	keys := make([]string, 0, len(names))
+	for key := range names {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	slugs := map[string]string{}
+	taken := map[string]bool{}
+	for _, key := range keys {
+		song := names[key]
+		base := Slugify(song.Name)
+		if len(song.Artists) > 0 {
+			base = Slugify(song.Name + " " + song.Artists[0].Name)
+		}
+		if base == "" {
+			base = "song"
+		}
+		slug := base
+		for i := 2; taken[slug]; i++ {
+			slug = fmt.Sprintf("%s-%d", base, i)
+		}
+		taken[slug] = true
+		slugs[key] = slug
+	}
+
+	for _, memory := range memories {
+		for _, songs := range [][]Song{memory.Songs, memory.OtherSongs} {
+			for i := range songs {
+				songs[i].Slug = slugs[songs[i].Key()]
+			}
+		}
+	}
+}
+
+// buildSongPages groups appearances by song, in order of first appearance.
+func buildSongPages(memories []Memory) []SongPage {
+	var pages []*SongPage
+	bySlug := map[string]*SongPage{}
+	forEachSong(memories, func(memory Memory, song Song, other bool) {
+		page, ok := bySlug[song.Slug]
+		if !ok {
+			page = &SongPage{Song: song}
+			bySlug[song.Slug] = page
+			pages = append(pages, page)
+		}
+		if page.ImageLink == "" {
+			page.ImageLink = song.ImageLink
+		}
+		page.Appearances = append(page.Appearances, Appearance{Song: song, Memory: memory, Other: other})
+		if n := len(page.Memories); n == 0 || page.Memories[n-1].OutputTitle != memory.OutputTitle {
+			page.Memories = append(page.Memories, memory)
+		}
+	})
+
+	out := make([]SongPage, len(pages))
+	for i, page := range pages {
+		out[i] = *page
+	}
+	return out
+}
+
+// forEachSong visits every song in every memory, main songs before other songs.
+func forEachSong(memories []Memory, fn func(memory Memory, song Song, other bool)) {
+	for _, memory := range memories {
+		for _, song := range memory.Songs {
+			fn(memory, song, false)
+		}
+		for _, song := range memory.OtherSongs {
+			fn(memory, song, true)
+		}
+	}
+}
@@ -2,10 +2,8 @@ package sonostalgia
 
 import (
 	"fmt"
-	"log"
 	"sort"
 	"strconv"
-	"strings"
 )
 
 // All the parsed template params
@@ -15,6 +13,12 @@ type Sonostalgia struct {
 	MemoriesParams Memories
 	YearsParams    Years
 	MemoryParams   []Memory
+	ArtistsParams  Artists
+	ArtistParams   []ArtistPage
+	SongParams     []SongPage
+	TagsParams     Tags
+	TagParams      []TagPage // tags and people
+	MapParams      Map
 }
 
 func LoadSonostalgia(memoryFiles []string) (*Sonostalgia, error) {
@@ -27,14 +31,17 @@ func LoadSonostalgia(memoryFiles []string) (*Sonostalgia, error) {
 		memories = append(memories, *memory)
 	}
 
-	// Index
-	//MemoryCount = just take len
-	// Hmm unique songs?? uniqueuness is artist x title
-	//SongCount = sum(len(songs)) over all memories
-	//ArtistCount = sum(len(songs)) over all memories
-	//YearsWithEntries = unique 2xxx numbers that appear in dates?
-	// this will undercount it I do ranges like 2017-2019
-	//RecentMemories = ??? take some from the current year?
+	// Most recent first. Ties (and undated memories) fall back to the filename order.
+	sort.SliceStable(memories, func(i, j int) bool {
+		return memories[j].Date.Before(memories[i].Date)
+	})
+
+	assignArtistSlugs(memories)
+	assignSongSlugs(memories)
+	artistPages := buildArtistPages(memories)
+	songPages := buildSongPages(memories)
+	tagPages := buildTagPages(memories, false, func(m Memory) []string { return m.Tags })
+	peoplePages := buildTagPages(memories, true, func(m Memory) []string { return m.People })
 
 	var (
 		memoryCount        = len(memories)
@@ -46,30 +53,24 @@ func LoadSonostalgia(memoryFiles []string) (*Sonostalgia, error) {
 		yearsForParams     []Year
 	)
 
-	// string is of form "song|artist"
+	// keyed by Song.Key
 	songSet := map[string]struct{}{}
 	artistSet := map[string]struct{}{}
-	yearSet := map[string][]Memory{}
+	yearSet := map[int][]Memory{}
 
 	for _, memory := range memories {
 
 		// Add to song and artist sets.
 		// Account for duplicate songs, and songs with same name
 		for _, song := range memory.Songs {
-			// TODO haven't really bothered with multi artists in the song hash
-			songSet[fmt.Sprintf("%s|%s|%d", song.Name, song.Artists[0], len(song.Artists))] = struct{}{}
+			songSet[song.Key()] = struct{}{}
 			for _, artist := range song.Artists {
 				artistSet[artist.Name] = struct{}{}
 			}
 		}
 
-		parsedDates := parseDateString(memory.Date)
-		for _, year := range parsedDates {
-			if yearMemories, ok := yearSet[year]; ok {
-				yearSet[year] = append(yearMemories, memory)
-			} else {
-				yearSet[year] = []Memory{memory}
-			}
+		for _, year := range memory.Date.Years() {
+			yearSet[year] = append(yearSet[year], memory)
 		}
 	}
 
@@ -77,15 +78,11 @@ func LoadSonostalgia(memoryFiles []string) (*Sonostalgia, error) {
 
 	minYear := 3000
 	for year, yearMemories := range yearSet {
-		yearInt, err := strconv.Atoi(year)
-		if err != nil {
-			continue
-		}
-		if yearInt < minYear {
-			minYear = yearInt
+		if year < minYear {
+			minYear = year
 		}
 		yearsForParams = append(yearsForParams, Year{
-			Year:     yearInt,
+			Year:     year,
 			Memories: yearMemories,
 		})
 	}
@@ -98,7 +95,7 @@ func LoadSonostalgia(memoryFiles []string) (*Sonostalgia, error) {
 	songCount = len(songSet)
 	artistCount = len(artistSet)
 	yearsWithEntries = len(yearSet)
-	recentMemories = memories[max(len(memories), 5)-5:] // TODO
+	recentMemories = memories[:min(len(memories), 5)]
 	earliestMemoryYear = strconv.Itoa(minYear)
 
 	return &Sonostalgia{
@@ -122,50 +119,16 @@ func LoadSonostalgia(memoryFiles []string) (*Sonostalgia, error) {
 		YearsParams: Years{
 			Years: yearsForParams,
 		},
+		ArtistsParams: Artists{
+			Artists: artistPages,
+		},
+		ArtistParams: artistPages,
+		SongParams:   songPages,
+		TagsParams: Tags{
+			Tags:   tagPages,
+			People: peoplePages,
+		},
+		TagParams: append(tagPages, peoplePages...),
+		MapParams: buildMap(memories),
 	}, nil
 }
-
-// it's freeform but we'll hope for the following:
-// A single year: "2019"
-// A range of years: "2019-2022"
-// A list of years: 2019,2020,2022
-func parseDateString(dateString string) []string {
-	dateString = strings.TrimSpace(dateString)
-
-	if strings.Contains(dateString, "-") {
-		log.Printf("trying to parse date %s as a range\n", dateString)
-		rangeDates := strings.Split(dateString, "-")
-		if len(rangeDates) != 2 {
-			log.Printf("thought date %s was a range but had too wrong number of parts\n", dateString)
-			return []string{}
-		}
-		// parse to ints, range between the two, convert back to stirng and add to set
-		begin, err := strconv.Atoi(rangeDates[0])
-		if err != nil {
-			log.Printf("failed to convert start year to int\n")
-			return []string{}
-		}
-		end, err := strconv.Atoi(rangeDates[1])
-		if err != nil {
-			log.Printf("failed to convert start year to int\n")
-			return []string{}
-		}
-		dates := []string{}
-		for i := begin; i <= end; i++ {
-			dates = append(dates, strconv.Itoa(i))
-		}
-		log.Printf("dates: %s\n", dates)
-		return dates
-	} else if strings.Contains(dateString, ",") {
-		log.Printf("trying to parse date %s as a list\n", dateString)
-		dates := strings.Split(dateString, ",")
-		log.Printf("dates: %s\n", dates)
-		return dates
-	} else {
-		// assume it's fine
-		log.Printf("trying to parse date %s as a single year\n", dateString)
-		dates := []string{dateString}
-		log.Printf("dates: %s\n", dates)
-		return dates
-	}
-}
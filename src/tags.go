@@ -0,0 +1,62 @@
+package sonostalgia
+
+import (
+	"sort"
+	"strings"
+)
+
+// Tags is the tag index, covering both tags and people.
+type Tags struct {
+	Tags   []TagPage
+	People []TagPage
+}
+
+// TagPage lists every memory with a particular tag, or featuring a particular person.
+type TagPage struct {
+	Name     string
+	Slug     string
+	Person   bool
+	Memories []Memory
+}
+
+// Dir is the output directory for the page, relative to the site root.
+func (t TagPage) Dir() string {
+	if t.Person {
+		return "people"
+	}
+	return "tags"
+}
+
+// buildTagPages groups memories by the labels get returns for them. Labels that slugify
+// the same ("Road trip", "road-trip") are treated as one, named after the first seen.
+func buildTagPages(memories []Memory, person bool, get func(Memory) []string) []TagPage {
+	bySlug := map[string]*TagPage{}
+	for _, memory := range memories {
+		for _, label := range get(memory) {
+			slug := Slugify(label)
+			if slug == "" {
+				continue
+			}
+			page, ok := bySlug[slug]
+			if !ok {
+				page = &TagPage{Name: strings.TrimSpace(label), Slug: slug, Person: person}
+				bySlug[slug] = page
+			}
+			if n := len(page.Memories); n == 0 || page.Memories[n-1].OutputTitle != memory.OutputTitle {
+				page.Memories = append(page.Memories, memory)
+			}
+		}
+	}
+
+	pages := make([]TagPage, 0, len(bySlug))
+	for _, page := range bySlug {
+		pages = append(pages, *page)
+	}
+	sort.Slice(pages, func(i, j int) bool {
+		if len(pages[i].Memories) != len(pages[j].Memories) {
+			return len(pages[i].Memories) > len(pages[j].Memories)
+		}
+		return strings.ToLower(pages[i].Name) < strings.ToLower(pages[j].Name)
+	})
+	return pages
+}
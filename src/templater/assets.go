@@ -0,0 +1,191 @@
+package templater
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// assetRefRe finds links into the assets directory in any text: imageLinks, markdown images in
+// descriptions and notes in WIP files alike. Links inside URLs or longer paths aren't local, so
+// the link has to start a word, optionally as ./assets/.
+var assetRefRe = regexp.MustCompile(`(?:^|[^\w/.-])(?:\./)?(assets/[^\s"'()<>\[\]]+)`)
+
+// AssetRef is a link to an asset found in a source file.
+type AssetRef struct {
+	File string
+	Line int
+	Link string
+}
+
+func (r AssetRef) String() string {
+	return fmt.Sprintf("%s:%d: %s", r.File, r.Line, r.Link)
+}
+
+// AssetReport cross-references the files in the assets directory with the links to them.
+// Paths are relative to the source directory, like the links themselves.
+type AssetReport struct {
+	// Orphans are assets nothing links to.
+	Orphans []string
+	// Missing are links to assets that don't exist.
+	Missing []AssetRef
+	// Duplicates are groups of byte-identical assets, whether or not they're used.
+	Duplicates [][]string
+}
+
+// Assets checks every asset under srcDir against the links to them in memories, WIP memories
+// and templates.
+func Assets(srcDir string) (*AssetReport, error) {
+	sources, err := sourceHashes(srcDir)
+	if err != nil {
+		return nil, err
+	}
+	byHash := map[string][]string{}
+	for _, asset := range matching(sources, func(path string) bool { return strings.HasPrefix(path, "assets/") }) {
+		byHash[sources[asset]] = append(byHash[sources[asset]], asset)
+	}
+
+	refs, err := assetRefs(srcDir)
+	if err != nil {
+		return nil, err
+	}
+	report := &AssetReport{}
+	linked := map[string]bool{}
+	for _, ref := range refs {
+		linked[ref.Link] = true
+		if _, ok := sources[ref.Link]; !ok {
+			report.Missing = append(report.Missing, ref)
+		}
+	}
+	for _, assets := range byHash {
+		for _, asset := range assets {
+			if !linked[asset] {
+				report.Orphans = append(report.Orphans, asset)
+			}
+		}
+		if len(assets) > 1 {
+			report.Duplicates = append(report.Duplicates, assets)
+		}
+	}
+	slices.Sort(report.Orphans)
+	slices.SortFunc(report.Duplicates, func(a, b []string) int { return strings.Compare(a[0], b[0]) })
+	return report, nil
+}
+
+// assetRefs finds every link to an asset in the files that can hold one.
+func assetRefs(srcDir string) ([]AssetRef, error) {
+	files, err := filepath.Glob(memoryPattern(srcDir))
+	if err != nil {
+		return nil, err
+	}
+	templates, err := filepath.Glob(filepath.Join(srcDir, "templates/*"))
+	if err != nil {
+		return nil, err
+	}
+	files = append(files, templates...)
+
+	wipDir := filepath.Join(srcDir, "wip-memories")
+	err = filepath.WalkDir(wipDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && path == wipDir {
+				return nil
+			}
+			return err
+		}
+		if !d.IsDir() {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var refs []AssetRef
+	for _, file := range files {
+		fileRefs, err := scanAssetRefs(file)
+		if err != nil {
+			return nil, err
+		}
+		refs = append(refs, fileRefs...)
+	}
+	return refs, nil
+}
+
+func scanAssetRefs(file string) ([]AssetRef, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var refs []AssetRef
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		for _, match := range assetRefRe.FindAllStringSubmatch(scanner.Text(), -1) {
+			// Links at the end of a sentence in notes pick up its punctuation.
+			link := path.Clean(strings.TrimRight(match[1], ".,;:!?"))
+			refs = append(refs, AssetRef{File: file, Line: line, Link: link})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", file, err)
+	}
+	return refs, nil
+}
+
+// RemoveAssets deletes the given assets from srcDir or, if trashDir isn't empty, moves them
+// there, keeping their paths so they're easy to put back. Nothing in trashDir is overwritten:
+// an asset that's already there is moved in beside it with a number added to its name.
+func RemoveAssets(srcDir string, assets []string, trashDir string) error {
+	for _, asset := range assets {
+		file := filepath.Join(srcDir, filepath.FromSlash(asset))
+		if trashDir == "" {
+			if err := os.Remove(file); err != nil {
+				return err
+			}
+			continue
+		}
+		dest, err := freeName(filepath.Join(trashDir, filepath.FromSlash(asset)))
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return err
+		}
+		if err := os.Rename(file, dest); err != nil {
+			// The trash can be on another file system, which rename can't move across.
+			if err := copyFile(file, dest); err != nil {
+				return err
+			}
+			if err := os.Remove(file); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// freeName returns file, or if that exists, the first of file-1, file-2 and so on that doesn't.
+func freeName(file string) (string, error) {
+	ext := filepath.Ext(file)
+	stem := strings.TrimSuffix(file, ext)
+	for n := 0; ; n++ {
+		name := file
+		if n > 0 {
+			name = fmt.Sprintf("%s-%d%s", stem, n, ext)
+		}
+		if _, err := os.Lstat(name); errors.Is(err, fs.ErrNotExist) {
+			return name, nil
+		} else if err != nil {
+			return "", err
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package templater
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		file := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestAssets(t *testing.T) {
+	src := t.TempDir()
+	writeFiles(t, src, map[string]string{
+		"memories/gig.yaml": "songs:\n  - name: Used\n    imageLink: \"assets/used.jpg\"\n  - name: Gone\n    imageLink: ./assets/gone.jpg\n" +
+			"  - name: Remote\n    imageLink: https://example.com/assets/remote.jpg\n",
+		"wip-memories/for-later/trip": "--name trip\nuse assets/planned.png for the header.\n",
+		"assets/used.jpg":             "cover",
+		"assets/copy.jpg":             "cover",
+		"assets/planned.png":          "header",
+		"assets/old.jpg":              "old",
+	})
+
+	report, err := Assets(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"assets/copy.jpg", "assets/old.jpg"}; !slices.Equal(report.Orphans, want) {
+		t.Errorf("orphans = %v, want %v", report.Orphans, want)
+	}
+	want := AssetRef{File: filepath.Join(src, "memories/gig.yaml"), Line: 5, Link: "assets/gone.jpg"}
+	if len(report.Missing) != 1 || report.Missing[0] != want {
+		t.Errorf("missing = %v, want %v", report.Missing, want)
+	}
+	if len(report.Duplicates) != 1 || !slices.Equal(report.Duplicates[0], []string{"assets/copy.jpg", "assets/used.jpg"}) {
+		t.Errorf("duplicates = %v, want copy.jpg and used.jpg", report.Duplicates)
+	}
+
+	// Orphans moved to the trash twice are both kept.
+	trash := t.TempDir()
+	writeFiles(t, trash, map[string]string{"assets/old.jpg": "older"})
+	if err := RemoveAssets(src, report.Orphans, trash); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{"assets/copy.jpg", "assets/old.jpg"} {
+		if _, err := os.Stat(filepath.Join(src, file)); err == nil {
+			t.Errorf("%s wasn't moved", file)
+		}
+	}
+	if got := readOutput(t, trash, "assets/old-1.jpg"); got != "old" {
+		t.Errorf("trashed old.jpg = %q, want the orphan", got)
+	}
+	if got := readOutput(t, trash, "assets/old.jpg"); got != "older" {
+		t.Errorf("the asset already in the trash was overwritten with %q", got)
+	}
+
+	if err := RemoveAssets(src, []string{"assets/planned.png"}, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(src, "assets/planned.png")); err == nil {
+		t.Error("deleted asset still exists")
+	}
+}
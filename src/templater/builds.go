@@ -0,0 +1,248 @@
+package templater
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Each build is rendered into a staging directory beside the output directory, and only
+// once every page has rendered is it published by pointing the output directory, which is a
+// symlink, at it. Replacing a symlink is atomic, so whatever serves the output directory sees
+// either the old site or the new one, never half of each. The build it replaced is kept so
+// that Rollback can restore it.
+//
+// For an output directory named output, the builds live in .output-builds:
+//
+//	output -> .output-builds/20250601-183000.000000000
+//	.output-builds/20250601-183000.000000000                 the live build
+//	.output-builds/20250601-183000.000000000.manifest.json   what it was built from
+//	.output-builds/20250528-091500.000000000                 the previous build
+//	.output-builds/staging-123456                            a build in progress
+//	.output-builds/cache                                     work kept between builds, like resized images
+
+const (
+	buildIDFormat = "20060102-150405.000000000"
+	stagingPrefix = "staging-"
+)
+
+func buildsDir(outputDir string) string {
+	return filepath.Join(filepath.Dir(outputDir), "."+filepath.Base(outputDir)+"-builds")
+}
+
+func imageCacheDir(outputDir string) string {
+	return filepath.Join(buildsDir(outputDir), "cache", "images")
+}
+
+// liveBuild returns the directory outputDir currently shows, or "" if nothing has been built.
+// Output directories from before builds were staged are real directories, and are their own live build.
+func liveBuild(outputDir string) (string, error) {
+	info, err := os.Lstat(outputDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if info.Mode()&fs.ModeSymlink == 0 {
+		return outputDir, nil
+	}
+	target, err := os.Readlink(outputDir)
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(outputDir), target)
+	}
+	return target, nil
+}
+
+// stage creates a directory to build into. Unless fresh is set it starts as a copy of the live
+// build, so that unchanged pages can be skipped. The copy is made of hard links where possible,
+// which is why pages and assets are always replaced rather than written over.
+func stage(outputDir, live string, fresh bool) (string, error) {
+	builds := buildsDir(outputDir)
+	if err := os.MkdirAll(builds, 0755); err != nil {
+		return "", err
+	}
+	staging, err := os.MkdirTemp(builds, stagingPrefix)
+	if err != nil {
+		return "", err
+	}
+	if err := os.Chmod(staging, 0755); err != nil {
+		return "", err
+	}
+	if live == "" || fresh {
+		return staging, nil
+	}
+
+	err = filepath.WalkDir(live, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(live, path)
+		if err != nil {
+			return err
+		}
+		dest := filepath.Join(staging, rel)
+		if d.IsDir() {
+			return os.MkdirAll(dest, 0755)
+		}
+		if err := os.Link(path, dest); err != nil {
+			return copyFile(path, dest)
+		}
+		return nil
+	})
+	if err != nil {
+		os.RemoveAll(staging)
+		return "", fmt.Errorf("copying the live build: %w", err)
+	}
+	if err := os.Remove(filepath.Join(staging, legacyManifestName)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		os.RemoveAll(staging)
+		return "", err
+	}
+	return staging, nil
+}
+
+// publish makes staging the live build and removes every build but it and the one it replaced.
+func publish(outputDir, staging string) error {
+	builds := buildsDir(outputDir)
+	id := time.Now().UTC().Format(buildIDFormat)
+	build := filepath.Join(builds, id)
+	if err := os.Rename(manifestPath(staging), manifestPath(build)); err != nil {
+		return err
+	}
+	if err := os.Rename(staging, build); err != nil {
+		return err
+	}
+
+	previous, err := liveBuild(outputDir)
+	if err != nil {
+		return err
+	}
+	if previous == outputDir {
+		// The output directory is a build from before staging; move it aside to keep it.
+		previous, err = adoptLegacyBuild(outputDir)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := pointAt(outputDir, build); err != nil {
+		return err
+	}
+	log.Printf("Published build %s", id)
+
+	keep := []string{id}
+	if previous != "" {
+		keep = append(keep, filepath.Base(previous))
+		log.Printf("Kept build %s for rollback", filepath.Base(previous))
+	}
+	for _, name := range listBuilds(builds) {
+		if slices.Contains(keep, name) {
+			continue
+		}
+		if err := removeBuild(filepath.Join(builds, name)); err != nil {
+			log.Printf("failed to remove old build %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// removeBuild deletes a build and its manifest.
+func removeBuild(build string) error {
+	if err := os.Remove(manifestPath(build)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return os.RemoveAll(build)
+}
+
+// adoptLegacyBuild moves a real output directory into the builds directory, returning where it
+// went, or "" if it was empty and has simply been removed.
+func adoptLegacyBuild(outputDir string) (string, error) {
+	entries, err := os.ReadDir(outputDir)
+	if err != nil {
+		return "", err
+	}
+	if len(entries) == 0 {
+		return "", os.Remove(outputDir)
+	}
+	info, err := os.Stat(outputDir)
+	if err != nil {
+		return "", err
+	}
+	build := filepath.Join(buildsDir(outputDir), info.ModTime().UTC().Format(buildIDFormat))
+	if err := os.Rename(outputDir, build); err != nil {
+		return "", fmt.Errorf("moving the existing output directory aside: %w", err)
+	}
+	return build, nil
+}
+
+// pointAt atomically replaces the outputDir symlink with one to build.
+func pointAt(outputDir, build string) error {
+	target, err := filepath.Rel(filepath.Dir(outputDir), build)
+	if err != nil {
+		return err
+	}
+	link := filepath.Join(buildsDir(outputDir), fmt.Sprintf("link-%d", time.Now().UnixNano()))
+	// The link's target is relative to the output directory's parent, where it ends up, not to where it's made.
+	if err := os.Symlink(target, link); err != nil {
+		return err
+	}
+	return os.Rename(link, outputDir)
+}
+
+// listBuilds returns the names of the finished builds in builds, oldest first.
+func listBuilds(builds string) []string {
+	entries, err := os.ReadDir(builds)
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() || strings.HasPrefix(e.Name(), stagingPrefix) {
+			continue
+		}
+		if _, err := time.Parse(buildIDFormat, e.Name()); err != nil {
+			continue
+		}
+		names = append(names, e.Name())
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Rollback makes the build before the live one live again, returning its name. Rolling back
+// twice returns to where you started, as the build rolled back from is kept.
+func Rollback(outputDir string) (string, error) {
+	live, err := liveBuild(outputDir)
+	if err != nil {
+		return "", err
+	}
+	if live == outputDir {
+		return "", errors.New("the output directory predates kept builds, so there is nothing to roll back to")
+	}
+
+	builds := listBuilds(buildsDir(outputDir))
+	current := filepath.Base(live)
+	var target string
+	for _, name := range builds {
+		if name != current {
+			target = name
+		}
+	}
+	if target == "" {
+		return "", errors.New("there is no previous build to roll back to")
+	}
+
+	if err := pointAt(outputDir, filepath.Join(buildsDir(outputDir), target)); err != nil {
+		return "", err
+	}
+	return target, nil
+}
@@ -0,0 +1,162 @@
+package templater
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func readOutput(t *testing.T, out, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(out, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func editFile(t *testing.T, path, old, new string) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), old) {
+		t.Fatalf("%s doesn't contain %q", path, old)
+	}
+	if err := os.WriteFile(path, []byte(strings.Replace(string(data), old, new, 1)), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFailedBuildLeavesLiveSite(t *testing.T) {
+	src := fixtureSrc(t)
+	out := filepath.Join(t.TempDir(), "output")
+	if err := Run(src, out, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	before := readOutput(t, out, "first-gig.html")
+
+	editFile(t, filepath.Join(src, "memories/first-gig.yaml"), "title: My First Gig", "title: My Very First Gig")
+	editFile(t, filepath.Join(src, "templates/memory.template.html"), "{{.Title}}", "{{.Title.NoSuchField}}")
+	if err := Run(src, out, Options{}); err == nil {
+		t.Fatal("build with a broken template succeeded")
+	}
+
+	if got := readOutput(t, out, "first-gig.html"); got != before {
+		t.Error("a failed build changed the live site")
+	}
+	if builds := listBuilds(buildsDir(out)); len(builds) != 1 {
+		t.Errorf("builds after a failure = %v, want just the first", builds)
+	}
+	if staging, _ := filepath.Glob(filepath.Join(buildsDir(out), stagingPrefix+"*")); len(staging) > 0 {
+		t.Errorf("failed build left %v behind", staging)
+	}
+}
+
+func TestRollback(t *testing.T) {
+	src := fixtureSrc(t)
+	out := filepath.Join(t.TempDir(), "output")
+	if _, err := Rollback(out); err == nil {
+		t.Error("rolled back before anything was built")
+	}
+
+	memory := filepath.Join(src, "memories/first-gig.yaml")
+	titles := []string{"My First Gig", "Second Title", "Third Title"}
+	for i, title := range titles {
+		if i > 0 {
+			editFile(t, memory, "title: "+titles[i-1], "title: "+title)
+		}
+		if err := Run(src, out, Options{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if builds := listBuilds(buildsDir(out)); len(builds) != 2 {
+		t.Errorf("kept builds %v, want the live one and the one before", builds)
+	}
+
+	if _, err := Rollback(out); err != nil {
+		t.Fatal(err)
+	}
+	if page := readOutput(t, out, "first-gig.html"); !strings.Contains(page, "Second Title") {
+		t.Error("rolling back didn't restore the previous build")
+	}
+	if _, err := Rollback(out); err != nil {
+		t.Fatal(err)
+	}
+	if page := readOutput(t, out, "first-gig.html"); !strings.Contains(page, "Third Title") {
+		t.Error("rolling back twice didn't return to the latest build")
+	}
+
+	// Later builds still start from whatever is live.
+	editFile(t, memory, "title: Third Title", "title: Fourth Title")
+	if err := Run(src, out, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if page := readOutput(t, out, "index.html"); !strings.Contains(page, "Fourth Title") {
+		t.Error("building after a rollback didn't update the site")
+	}
+}
+
+func TestExistingOutputIsKeptForRollback(t *testing.T) {
+	src := fixtureSrc(t)
+	out := filepath.Join(t.TempDir(), "output")
+	if err := os.MkdirAll(out, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(out, "index.html"), []byte("an old build"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Run(src, out, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if page := readOutput(t, out, "index.html"); page == "an old build" {
+		t.Fatal("the site wasn't rebuilt")
+	}
+	if _, err := Rollback(out); err != nil {
+		t.Fatal(err)
+	}
+	if page := readOutput(t, out, "index.html"); page != "an old build" {
+		t.Error("rolling back didn't restore the output directory from before builds were kept")
+	}
+}
+
+func TestManifestIsNotPublished(t *testing.T) {
+	src := fixtureSrc(t)
+	out := filepath.Join(t.TempDir(), "output")
+	if err := Run(src, out, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	live, err := liveBuild(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(manifestPath(live)); err != nil {
+		t.Errorf("the live build has no manifest beside it: %v", err)
+	}
+
+	// Builds from when the manifest was kept inside them stop publishing it.
+	if err := os.WriteFile(filepath.Join(live, legacyManifestName), []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	memory := filepath.Join(src, "memories/first-gig.yaml")
+	editFile(t, memory, "title: My First Gig", "title: Second Title")
+	if err := Run(src, out, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(out, legacyManifestName)); err == nil {
+		t.Error("the old manifest is still published")
+	}
+
+	// Old builds' manifests go with them.
+	editFile(t, memory, "title: Second Title", "title: Third Title")
+	if err := Run(src, out, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	manifests, _ := filepath.Glob(filepath.Join(buildsDir(out), "*"+manifestSuffix))
+	if len(manifests) != 2 {
+		t.Errorf("manifests = %v, want one for each kept build", manifests)
+	}
+}
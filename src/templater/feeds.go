@@ -0,0 +1,237 @@
+package templater
+
+import (
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	sonostalgia "github.com/azoghal/sonostalgia/src"
+)
+
+const (
+	feedTitle       = "Sonostalgia"
+	feedDescription = "Songs that conjure a memory"
+)
+
+// feedEntry is everything the Atom and RSS feeds say about a memory.
+type feedEntry struct {
+	memory    sonostalgia.Memory
+	link      string
+	published time.Time
+	content   string // HTML
+	enclosure *feedEnclosure
+}
+
+type feedEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int64  `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+// feedEntries builds one entry per memory, newest first. Links are made absolute
+// with siteURL; without it they're left relative to the site root.
+func feedEntries(srcDir, siteURL string, memories []sonostalgia.Memory) []feedEntry {
+	entries := make([]feedEntry, len(memories))
+	for i, memory := range memories {
+		entries[i] = feedEntry{
+			memory:    memory,
+			link:      absoluteURL(siteURL, memory.OutputTitle+".html"),
+			published: memory.PublishedTime(),
+			content:   feedContent(memory),
+			enclosure: coverEnclosure(srcDir, siteURL, memory),
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].published.After(entries[j].published)
+	})
+	return entries
+}
+
+func feedContent(memory sonostalgia.Memory) string {
+	var b strings.Builder
+	if memory.Subtitle != "" {
+		fmt.Fprintf(&b, "<p><em>%s</em></p>\n", html.EscapeString(memory.Subtitle))
+	}
+	if len(memory.Songs) > 0 {
+		b.WriteString("<ul>\n")
+		for _, song := range memory.Songs {
+			artists := make([]string, len(song.Artists))
+			for i, artist := range song.Artists {
+				artists[i] = artist.Name
+			}
+			name := html.EscapeString(song.Name)
+			if song.SongLink != "" {
+				name = fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(song.SongLink), name)
+			}
+			fmt.Fprintf(&b, "<li>%s – %s</li>\n", name, html.EscapeString(strings.Join(artists, ", ")))
+		}
+		b.WriteString("</ul>\n")
+	}
+	b.WriteString(renderMarkdown(memory.Content))
+	return b.String()
+}
+
+// coverEnclosure describes the memory's first cover image, if it has one in the assets directory.
+func coverEnclosure(srcDir, siteURL string, memory sonostalgia.Memory) *feedEnclosure {
+	for _, song := range memory.Songs {
+		if song.ImageLink == "" {
+			continue
+		}
+		info, err := os.Stat(filepath.Join(srcDir, filepath.FromSlash(song.ImageLink)))
+		if err != nil {
+			continue
+		}
+		mimeType := mime.TypeByExtension(path.Ext(song.ImageLink))
+		if mimeType == "" {
+			mimeType = "application/octet-stream"
+		}
+		return &feedEnclosure{URL: absoluteURL(siteURL, song.ImageLink), Length: info.Size(), Type: mimeType}
+	}
+	return nil
+}
+
+func absoluteURL(siteURL, rel string) string {
+	return strings.TrimSuffix(siteURL, "/") + "/" + rel
+}
+
+type atomFeed struct {
+	XMLName  xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title    string      `xml:"title"`
+	Subtitle string      `xml:"subtitle"`
+	ID       string      `xml:"id"`
+	Links    []atomLink  `xml:"link"`
+	Updated  string      `xml:"updated"`
+	Author   atomAuthor  `xml:"author"`
+	Entries  []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href   string `xml:"href,attr"`
+	Rel    string `xml:"rel,attr,omitempty"`
+	Type   string `xml:"type,attr,omitempty"`
+	Length int64  `xml:"length,attr,omitempty"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type atomEntry struct {
+	Title     string     `xml:"title"`
+	ID        string     `xml:"id"`
+	Links     []atomLink `xml:"link"`
+	Published string     `xml:"published"`
+	Updated   string     `xml:"updated"`
+	Summary   string     `xml:"summary,omitempty"`
+	Content   atomText   `xml:"content"`
+}
+
+func writeAtom(w io.Writer, siteURL string, entries []feedEntry) error {
+	feed := atomFeed{
+		Title:    feedTitle,
+		Subtitle: feedDescription,
+		ID:       absoluteURL(siteURL, ""),
+		Links: []atomLink{
+			{Href: absoluteURL(siteURL, "feed.xml"), Rel: "self", Type: "application/atom+xml"},
+			{Href: absoluteURL(siteURL, "index.html"), Rel: "alternate", Type: "text/html"},
+		},
+		Updated: feedUpdated(entries).Format(time.RFC3339),
+		Author:  atomAuthor{Name: feedTitle},
+	}
+	for _, e := range entries {
+		links := []atomLink{{Href: e.link, Rel: "alternate", Type: "text/html"}}
+		if e.enclosure != nil {
+			links = append(links, atomLink{Href: e.enclosure.URL, Rel: "enclosure", Type: e.enclosure.Type, Length: e.enclosure.Length})
+		}
+		feed.Entries = append(feed.Entries, atomEntry{
+			Title:     e.memory.Title,
+			ID:        e.link,
+			Links:     links,
+			Published: e.published.Format(time.RFC3339),
+			Updated:   e.published.Format(time.RFC3339),
+			Summary:   e.memory.Subtitle,
+			Content:   atomText{Type: "html", Body: e.content},
+		})
+	}
+	return writeXML(w, feed)
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string         `xml:"title"`
+	Link        string         `xml:"link"`
+	GUID        string         `xml:"guid"`
+	PubDate     string         `xml:"pubDate"`
+	Description string         `xml:"description"`
+	Enclosure   *feedEnclosure `xml:"enclosure"`
+}
+
+func writeRSS(w io.Writer, siteURL string, entries []feedEntry) error {
+	feed := rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:         feedTitle,
+			Link:          absoluteURL(siteURL, "index.html"),
+			Description:   feedDescription,
+			LastBuildDate: feedUpdated(entries).Format(time.RFC1123Z),
+		},
+	}
+	for _, e := range entries {
+		feed.Channel.Items = append(feed.Channel.Items, rssItem{
+			Title:       e.memory.Title,
+			Link:        e.link,
+			GUID:        e.link,
+			PubDate:     e.published.Format(time.RFC1123Z),
+			Description: e.content,
+			Enclosure:   e.enclosure,
+		})
+	}
+	return writeXML(w, feed)
+}
+
+// feedUpdated is the publish time of the newest entry.
+func feedUpdated(entries []feedEntry) time.Time {
+	if len(entries) == 0 {
+		return time.Unix(0, 0).UTC()
+	}
+	return entries[0].published
+}
+
+func writeXML(w io.Writer, v any) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
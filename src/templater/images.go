@@ -0,0 +1,415 @@
+package templater
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/HugoSmits86/nativewebp"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+
+	sonostalgia "github.com/azoghal/sonostalgia/src"
+)
+
+// coverWidths are the widths cover art is resized to. Covers are shown 50px wide in song
+// lists, 60px on memory cards, 80px in search results and 160px on song pages, so these
+// cover each of them on normal and high density screens. Images are never enlarged.
+var coverWidths = []int{64, 128, 160, 320}
+
+const (
+	imageCacheVersion = 3
+	jpegQuality       = 82
+	// placeholderWidth is how wide the blurry copy shown while a cover loads is. Browsers smooth
+	// it as they scale it up, and at this size it adds only a couple of hundred bytes to a page.
+	placeholderWidth = 8
+)
+
+// imageInfo is what the cache records about a source image, which is keyed by its content hash.
+// The resized copies sit beside it as <hash>-<width>.jpg and, where kept, <hash>-<width>.webp.
+type imageInfo struct {
+	Version     int                 `json:"version"`
+	Width       int                 `json:"width"`
+	Height      int                 `json:"height"`
+	Variants    []imageVariant      `json:"variants"`
+	Palette     sonostalgia.Palette `json:"palette"`
+	Placeholder string              `json:"placeholder"` // a data URI, see placeholder
+}
+
+type imageVariant struct {
+	Width  int  `json:"width"`
+	Height int  `json:"height"`
+	WebP   bool `json:"webp"` // whether a WebP copy was kept, see prepareImage
+}
+
+// coverImage is a cover as templates see it, through the cover function.
+type coverImage struct {
+	Src        string // the smallest JPEG, or the original link if the image wasn't resized
+	Srcset     string // every JPEG with its width, empty if the image wasn't resized
+	WebPSrcset string // the WebP copies, often empty, see prepareImage
+	Width      int    // of the largest copy, so browsers can reserve space for it
+	Height     int
+	// Placeholder is a tiny copy of the image as a data URI, shown behind it until it loads.
+	Placeholder template.URL
+
+	// Set per use by the cover template function.
+	Alt   string
+	Class string
+	Sizes string
+
+	variants []imageOutput
+}
+
+// imageOutput is a resized copy of an image, written to the output directory from the cache.
+type imageOutput struct {
+	name      string // relative to the output directory, e.g. images/juno-1a2b3c4d5e-128.jpg
+	cacheFile string
+	width     int
+	webp      bool
+}
+
+// coverImages holds every processed cover, by the link memories use for it.
+type coverImages struct {
+	byLink   map[string]*coverImage
+	palettes map[string]sonostalgia.Palette
+	links    []string // the local images that were processed, sorted
+}
+
+// cover returns how a template should show the image at link, or nil for songs without one.
+// Images that weren't processed, like remote ones, are shown as they are.
+func (c *coverImages) cover(link, alt, class, sizes string) *coverImage {
+	if link == "" {
+		return nil
+	}
+	img := &coverImage{Src: link}
+	if processed, ok := c.byLink[path.Clean(link)]; ok {
+		img = new(coverImage)
+		*img = *processed
+	}
+	img.Alt, img.Class, img.Sizes = alt, class, sizes
+	return img
+}
+
+// setPalettes gives every song with a processed cover that cover's palette. Copies of a memory
+// in the template params share its songs, so this reaches every page the memory appears on.
+func (c *coverImages) setPalettes(memories []sonostalgia.Memory) {
+	for _, memory := range memories {
+		for _, songs := range [][]sonostalgia.Song{memory.Songs, memory.OtherSongs} {
+			for i := range songs {
+				if palette, ok := c.palettes[path.Clean(songs[i].ImageLink)]; ok {
+					songs[i].Palette = &palette
+				}
+			}
+		}
+	}
+}
+
+// at returns the smallest JPEG at least width pixels wide, falling back to the largest.
+func (c *coverImage) at(width int) string {
+	if c == nil {
+		return ""
+	}
+	src := c.Src
+	for _, v := range c.variants {
+		if v.webp {
+			continue
+		}
+		src = v.name
+		if v.width >= width {
+			break
+		}
+	}
+	return src
+}
+
+// imageLinks lists the local images used as song covers, as paths relative to the source directory.
+func imageLinks(songs []sonostalgia.Song, sources map[string]string) []string {
+	var links []string
+	for _, song := range songs {
+		if song.ImageLink == "" {
+			continue
+		}
+		link := path.Clean(song.ImageLink)
+		if _, ok := sources[link]; ok && !slices.Contains(links, link) {
+			links = append(links, link)
+		}
+	}
+	slices.Sort(links)
+	return links
+}
+
+// processImages resizes every cover used by a memory and finds its palette, reusing the
+// cached results for images seen before. Images that can't be decoded are logged and shown as they are.
+func processImages(srcDir, cacheDir string, memories []sonostalgia.Memory, sources map[string]string, workers int) (*coverImages, error) {
+	var songs []sonostalgia.Song
+	for _, memory := range memories {
+		songs = append(songs, memory.Songs...)
+		songs = append(songs, memory.OtherSongs...)
+	}
+	images := &coverImages{
+		byLink:   map[string]*coverImage{},
+		palettes: map[string]sonostalgia.Palette{},
+		links:    imageLinks(songs, sources),
+	}
+
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return nil, fmt.Errorf("creating image cache: %w", err)
+	}
+
+	infos := make([]*imageInfo, len(images.links))
+	var todo []int
+	for i, link := range images.links {
+		if info := loadImageInfo(cacheDir, sources[link]); info != nil {
+			infos[i] = info
+			continue
+		}
+		todo = append(todo, i)
+	}
+
+	errs := make([]error, len(images.links))
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for range min(workers, len(todo)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				link := images.links[i]
+				infos[i], errs[i] = prepareImage(filepath.Join(srcDir, filepath.FromSlash(link)), cacheDir, sources[link])
+			}
+		}()
+	}
+	for _, i := range todo {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+
+	resized := 0
+	for _, i := range todo {
+		link := images.links[i]
+		if errs[i] != nil {
+			var decodeErr decodeError
+			if !errors.As(errs[i], &decodeErr) {
+				return nil, fmt.Errorf("resizing %s: %w", link, errs[i])
+			}
+			log.Printf("Can't resize %s, so it will be used as it is: %v", link, errs[i])
+			continue
+		}
+		resized++
+	}
+	if resized > 0 {
+		log.Printf("Resized %d images", resized)
+	}
+
+	used := map[string]bool{}
+	for i, link := range images.links {
+		used[sources[link]] = true
+		if infos[i] != nil {
+			images.byLink[link] = newCoverImage(link, sources[link], cacheDir, infos[i])
+			images.palettes[link] = infos[i].Palette
+		}
+	}
+	pruneImageCache(cacheDir, used)
+
+	return images, nil
+}
+
+func newCoverImage(link, hash, cacheDir string, info *imageInfo) *coverImage {
+	stem := strings.TrimSuffix(path.Base(link), path.Ext(link))
+	img := &coverImage{}
+	var jpegs, webps []string
+	for _, v := range info.Variants {
+		name := fmt.Sprintf("images/%s-%s-%d", stem, hash[:10], v.Width)
+		cacheFile := filepath.Join(cacheDir, fmt.Sprintf("%s-%d", hash, v.Width))
+		img.variants = append(img.variants, imageOutput{name: name + ".jpg", cacheFile: cacheFile + ".jpg", width: v.Width})
+		jpegs = append(jpegs, fmt.Sprintf("%s.jpg %dw", name, v.Width))
+		if v.WebP {
+			img.variants = append(img.variants, imageOutput{name: name + ".webp", cacheFile: cacheFile + ".webp", width: v.Width, webp: true})
+			webps = append(webps, fmt.Sprintf("%s.webp %dw", name, v.Width))
+		}
+		img.Width, img.Height = v.Width, v.Height
+	}
+	img.Src = img.at(0)
+	// The placeholder is made from a decoded image by placeholder, so it's safe to use as a URL.
+	img.Placeholder = template.URL(info.Placeholder)
+	img.Srcset = strings.Join(jpegs, ", ")
+	img.WebPSrcset = strings.Join(webps, ", ")
+	return img
+}
+
+// loadImageInfo returns the cached copies of the image with the given hash, or nil if they need making.
+func loadImageInfo(cacheDir, hash string) *imageInfo {
+	data, err := os.ReadFile(filepath.Join(cacheDir, hash+".json"))
+	if err != nil {
+		return nil
+	}
+	var info imageInfo
+	if err := json.Unmarshal(data, &info); err != nil || info.Version != imageCacheVersion || len(info.Variants) == 0 {
+		return nil
+	}
+	for _, v := range info.Variants {
+		files := []string{fmt.Sprintf("%s-%d.jpg", hash, v.Width)}
+		if v.WebP {
+			files = append(files, fmt.Sprintf("%s-%d.webp", hash, v.Width))
+		}
+		for _, file := range files {
+			if _, err := os.Stat(filepath.Join(cacheDir, file)); err != nil {
+				return nil
+			}
+		}
+	}
+	return &info
+}
+
+// decodeError means a source image couldn't be read as an image at all.
+type decodeError struct{ err error }
+
+func (e decodeError) Error() string { return e.err.Error() }
+func (e decodeError) Unwrap() error { return e.err }
+
+// prepareImage writes a JPEG of the image at file at each of coverWidths into the cache,
+// and finds its palette and placeholder.
+//
+// There is no lossy WebP encoder written in Go, and the lossless one used here makes photos
+// several times larger than a JPEG. Flat artwork does compress well though, so a WebP copy
+// is only kept when it comes out smaller than the JPEG of the same width.
+func prepareImage(file, cacheDir, hash string) (*imageInfo, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	src, _, err := image.Decode(f)
+	f.Close()
+	if err != nil {
+		return nil, decodeError{err}
+	}
+
+	bounds := src.Bounds()
+	info := &imageInfo{Version: imageCacheVersion, Width: bounds.Dx(), Height: bounds.Dy(), Palette: extractPalette(src)}
+	if info.Placeholder, err = placeholder(src); err != nil {
+		return nil, err
+	}
+	var widths []int
+	for _, width := range coverWidths {
+		if width <= info.Width {
+			widths = append(widths, width)
+		}
+	}
+	if len(widths) == 0 {
+		widths = []int{info.Width}
+	}
+
+	for _, width := range widths {
+		height := max(1, (info.Height*width+info.Width/2)/info.Width)
+		dst := image.NewRGBA(image.Rect(0, 0, width, height))
+		draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Src, nil)
+
+		var jpg, webp bytes.Buffer
+		if err := jpeg.Encode(&jpg, dst, &jpeg.Options{Quality: jpegQuality}); err != nil {
+			return nil, err
+		}
+		if err := nativewebp.Encode(&webp, dst, nil); err != nil {
+			return nil, err
+		}
+
+		name := filepath.Join(cacheDir, fmt.Sprintf("%s-%d", hash, width))
+		if err := os.WriteFile(name+".jpg", jpg.Bytes(), 0644); err != nil {
+			return nil, err
+		}
+		variant := imageVariant{Width: width, Height: height, WebP: webp.Len() < jpg.Len()}
+		if variant.WebP {
+			if err := os.WriteFile(name+".webp", webp.Bytes(), 0644); err != nil {
+				return nil, err
+			}
+		}
+		info.Variants = append(info.Variants, variant)
+	}
+
+	data, err := json.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+	// The info is written last, so that an interrupted build never leaves it describing missing copies.
+	if err := os.WriteFile(filepath.Join(cacheDir, hash+".json"), data, 0644); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// placeholder shrinks src to placeholderWidth and returns it as a PNG data URI.
+func placeholder(src image.Image) (string, error) {
+	bounds := src.Bounds()
+	height := max(1, (bounds.Dy()*placeholderWidth+bounds.Dx()/2)/bounds.Dx())
+	small := image.NewRGBA(image.Rect(0, 0, placeholderWidth, height))
+	draw.CatmullRom.Scale(small, small.Bounds(), src, bounds, draw.Src, nil)
+
+	var buf bytes.Buffer
+	encoder := png.Encoder{CompressionLevel: png.BestCompression}
+	if err := encoder.Encode(&buf, small); err != nil {
+		return "", err
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// pruneImageCache removes cached copies of images that no memory uses any more.
+func pruneImageCache(cacheDir string, used map[string]bool) {
+	entries, err := os.ReadDir(cacheDir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		hash, _, _ := strings.Cut(strings.TrimSuffix(e.Name(), ".json"), "-")
+		if used[hash] {
+			continue
+		}
+		if err := os.Remove(filepath.Join(cacheDir, e.Name())); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("failed to remove %s from the image cache: %v", e.Name(), err)
+		}
+	}
+}
+
+// writeImages copies the resized covers into the output directory. Their names change with
+// their content, so a copy that's already there only needs recording.
+func writeImages(outputDir string, images *coverImages, sources map[string]string, previous, next *manifest) error {
+	written := 0
+	for _, link := range images.links {
+		img, ok := images.byLink[link]
+		if !ok {
+			continue
+		}
+		inputs := newInputSet(link)
+		for _, v := range img.variants {
+			if !previous.upToDate(outputDir, v.name, inputs, sources) {
+				dest := filepath.Join(outputDir, filepath.FromSlash(v.name))
+				if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+					return fmt.Errorf("creating images output directory: %w", err)
+				}
+				if err := copyFile(v.cacheFile, dest); err != nil {
+					return fmt.Errorf("writing %s: %w", v.name, err)
+				}
+				written++
+			}
+			next.record(v.name, inputs)
+		}
+	}
+
+	if written > 0 {
+		log.Printf("Wrote %d resized images", written)
+	}
+	return nil
+}
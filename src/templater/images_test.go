@@ -0,0 +1,152 @@
+package templater
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	sonostalgia "github.com/azoghal/sonostalgia/src"
+)
+
+// writeCover writes a width×height JPEG with a gradient, so that it doesn't compress to nothing.
+func writeCover(t *testing.T, file string, width, height int) {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		for x := range width {
+			img.Set(x, y, color.RGBA{uint8(x * 255 / width), uint8(y * 255 / height), uint8((x + y) % 256), 255})
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := jpeg.Encode(f, img, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func memoriesWithCovers(links ...string) []sonostalgia.Memory {
+	var songs []sonostalgia.Song
+	for _, link := range links {
+		songs = append(songs, sonostalgia.Song{Name: link, ImageLink: link})
+	}
+	return []sonostalgia.Memory{{Songs: songs}}
+}
+
+func TestPrepareImage(t *testing.T) {
+	tests := []struct {
+		width, height int
+		want          []int
+	}{
+		{500, 250, coverWidths},
+		{150, 150, []int{64, 128}},
+		{40, 30, []int{40}}, // too small to resize, but still gets a copy with known dimensions
+	}
+	for _, test := range tests {
+		src := filepath.Join(t.TempDir(), "cover.jpg")
+		writeCover(t, src, test.width, test.height)
+
+		info, err := prepareImage(src, t.TempDir(), "hash")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var widths []int
+		for _, v := range info.Variants {
+			widths = append(widths, v.Width)
+			if want := test.height * v.Width / test.width; v.Height != want {
+				t.Errorf("%dx%d resized to width %d has height %d, want %d", test.width, test.height, v.Width, v.Height, want)
+			}
+		}
+		if !slices.Equal(widths, test.want) {
+			t.Errorf("%dx%d was resized to widths %v, want %v", test.width, test.height, widths, test.want)
+		}
+
+		data, ok := strings.CutPrefix(info.Placeholder, "data:image/png;base64,")
+		if !ok {
+			t.Fatalf("placeholder %.40q isn't a PNG data URI", info.Placeholder)
+		}
+		decoded, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		placeholder, err := png.Decode(bytes.NewReader(decoded))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := placeholder.Bounds().Dx(); got != placeholderWidth {
+			t.Errorf("%dx%d has a placeholder %d pixels wide, want %d", test.width, test.height, got, placeholderWidth)
+		}
+	}
+}
+
+func TestProcessImages(t *testing.T) {
+	src := t.TempDir()
+	cache := t.TempDir()
+	writeCover(t, filepath.Join(src, "assets/big.jpg"), 400, 400)
+	writeCover(t, filepath.Join(src, "assets/gone.jpg"), 200, 200)
+	if err := os.WriteFile(filepath.Join(src, "assets/broken.jpg"), []byte("not a jpeg"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sources, err := sourceHashes(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	images, err := processImages(src, cache, memoriesWithCovers("assets/big.jpg", "assets/gone.jpg", "assets/broken.jpg", "https://example.com/remote.jpg"), sources, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	big := images.cover("assets/big.jpg", "Big", "cover", "60px")
+	if !strings.HasPrefix(big.Src, "images/big-"+sources["assets/big.jpg"][:10]) || !strings.HasSuffix(big.Src, "-64.jpg") {
+		t.Errorf("big.Src = %q, want its smallest copy", big.Src)
+	}
+	if n := strings.Count(big.Srcset, "w"); n != len(coverWidths) {
+		t.Errorf("big.Srcset = %q, want every width", big.Srcset)
+	}
+	if big.Width != 320 || big.Height != 320 || big.Alt != "Big" || big.Sizes != "60px" {
+		t.Errorf("big = %+v", big)
+	}
+	if got := big.at(100); !strings.HasSuffix(got, "-128.jpg") {
+		t.Errorf("big.at(100) = %q, want the 128px copy", got)
+	}
+	// Images that can't be resized are shown as they are.
+	for _, link := range []string{"assets/broken.jpg", "https://example.com/remote.jpg"} {
+		if img := images.cover(link, "", "", ""); img.Src != link || img.Srcset != "" {
+			t.Errorf("cover(%q) = %+v, want the original", link, img)
+		}
+	}
+	if images.cover("", "", "", "") != nil {
+		t.Error("a song without an image has a cover")
+	}
+
+	// The second time round everything comes from the cache, so the source files aren't needed.
+	if err := os.Remove(filepath.Join(src, "assets/big.jpg")); err != nil {
+		t.Fatal(err)
+	}
+	again, err := processImages(src, cache, memoriesWithCovers("assets/big.jpg"), sources, 2)
+	if err != nil {
+		t.Fatalf("processing cached images: %v", err)
+	}
+	if got := again.cover("assets/big.jpg", "Big", "cover", "60px"); got.Srcset != big.Srcset || got.Placeholder != big.Placeholder {
+		t.Errorf("cached cover = %+v, want %+v", got, big)
+	}
+
+	// Images no memory uses any more are dropped from the cache.
+	if leftovers, _ := filepath.Glob(filepath.Join(cache, sources["assets/gone.jpg"]+"*")); len(leftovers) > 0 {
+		t.Errorf("unused images are still cached: %v", leftovers)
+	}
+}
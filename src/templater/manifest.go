@@ -0,0 +1,242 @@
+package templater
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+const (
+	manifestSuffix  = ".manifest.json"
+	manifestVersion = 3
+	// legacyManifestName is where manifests used to be kept, inside the build, which
+	// published them along with the site.
+	legacyManifestName = ".build-manifest.json"
+)
+
+// manifest records the content hashes of the source files a build read, and for every
+// file written to the output directory, which of them it was built from. Paths are slash
+// separated; outputs are relative to the output directory and inputs to the source directory.
+//
+// Many outputs depend on every memory, so rather than repeat those long lists for each
+// output, each distinct list is stored once in Inputs and outputs refer to it by index.
+type manifest struct {
+	Version int               `json:"version"`
+	Sources map[string]string `json:"sources"`
+	Inputs  [][]string        `json:"inputs"`
+	Outputs map[string]int    `json:"outputs"`
+
+	recorded map[*inputSet]int // where each set recorded during this build is in Inputs
+	checked  map[setCheck]bool // upToDate's comparisons of a previous build's sets with this one's
+}
+
+// inputSet lists the source files an output is built from. Outputs with the same
+// dependencies share a set, which is how the manifest knows to store it once.
+type inputSet struct {
+	paths []string
+}
+
+func newInputSet(paths ...string) *inputSet {
+	return &inputSet{paths: paths}
+}
+
+type setCheck struct {
+	recorded int
+	current  *inputSet
+}
+
+func newManifest() *manifest {
+	return &manifest{
+		Version:  manifestVersion,
+		Sources:  map[string]string{},
+		Outputs:  map[string]int{},
+		recorded: map[*inputSet]int{},
+		checked:  map[setCheck]bool{},
+	}
+}
+
+// manifestPath is where the manifest of the build in buildDir is kept: beside it rather than
+// in it, as everything in a build is published.
+func manifestPath(buildDir string) string {
+	return filepath.Clean(buildDir) + manifestSuffix
+}
+
+// loadManifest reads the manifest of a previous build. A missing, unreadable or
+// outdated manifest just means everything gets rebuilt.
+func loadManifest(buildDir string) *manifest {
+	data, err := os.ReadFile(manifestPath(buildDir))
+	if err != nil {
+		return newManifest()
+	}
+	m := newManifest()
+	if err := json.Unmarshal(data, m); err != nil || m.Version != manifestVersion || m.Outputs == nil || m.Sources == nil {
+		return newManifest()
+	}
+	for _, i := range m.Outputs {
+		if i < 0 || i >= len(m.Inputs) {
+			return newManifest()
+		}
+	}
+	return m
+}
+
+func (m *manifest) save(buildDir string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := manifestPath(buildDir) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, manifestPath(buildDir))
+}
+
+// record notes that output was built from inputs.
+func (m *manifest) record(output string, inputs *inputSet) {
+	i, ok := m.recorded[inputs]
+	if !ok {
+		i = len(m.Inputs)
+		m.Inputs = append(m.Inputs, inputs.paths)
+		m.recorded[inputs] = i
+	}
+	m.Outputs[output] = i
+}
+
+// upToDate reports whether output was built from exactly these inputs, none of which
+// have changed since, and still exists. Sets are compared once, however many outputs share them.
+func (m *manifest) upToDate(outputDir, output string, inputs *inputSet, sources map[string]string) bool {
+	i, ok := m.Outputs[output]
+	if !ok {
+		return false
+	}
+	key := setCheck{recorded: i, current: inputs}
+	fresh, ok := m.checked[key]
+	if !ok {
+		fresh = slices.Equal(m.Inputs[i], inputs.paths)
+		for _, path := range inputs.paths {
+			if !fresh {
+				break
+			}
+			hash, ok := m.Sources[path]
+			fresh = ok && hash == sources[path]
+		}
+		m.checked[key] = fresh
+	}
+	if !fresh {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(outputDir, filepath.FromSlash(output)))
+	return err == nil
+}
+
+// covers reports whether the manifest was built from exactly the given sources,
+// i.e. nothing has been added, removed or changed since, and every output still exists.
+// The pages' pseudo-sources are left out, as they're only worked out while building and
+// can't change unless the sources have.
+func (m *manifest) covers(outputDir string, sources map[string]string) bool {
+	recorded := maps.Clone(m.Sources)
+	maps.DeleteFunc(recorded, func(path, _ string) bool { return strings.HasPrefix(path, pageSourcePrefix) })
+	if len(m.Outputs) == 0 || !maps.Equal(recorded, sources) {
+		return false
+	}
+	for output := range m.Outputs {
+		if _, err := os.Stat(filepath.Join(outputDir, filepath.FromSlash(output))); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// sourceHashes hashes every file the site is built from, keyed by slash separated path relative to srcDir.
+func sourceHashes(srcDir string) (map[string]string, error) {
+	hashes := map[string]string{}
+
+	patterns := []string{memoryPattern(srcDir), filepath.Join(srcDir, "templates/*"), filepath.Join(srcDir, worldOutline)}
+	for _, pattern := range patterns {
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			if err := addHash(hashes, srcDir, file); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	assetsIn := filepath.Join(srcDir, "assets")
+	err := filepath.WalkDir(assetsIn, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && path == assetsIn {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		return addHash(hashes, srcDir, path)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return hashes, nil
+}
+
+func addHash(hashes map[string]string, srcDir, file string) error {
+	info, err := os.Stat(file)
+	if err != nil || info.IsDir() {
+		return err
+	}
+	rel, err := filepath.Rel(srcDir, file)
+	if err != nil {
+		return err
+	}
+	hash, err := hashFile(file)
+	if err != nil {
+		return err
+	}
+	hashes[filepath.ToSlash(rel)] = hash
+	return nil
+}
+
+func hashString(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// matching returns the sorted source paths accepted by keep.
+func matching(sources map[string]string, keep func(path string) bool) []string {
+	var paths []string
+	for path := range sources {
+		if keep(path) {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
@@ -0,0 +1,98 @@
+package templater
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// runBuild runs the templater and returns the build it published.
+func runBuild(t *testing.T, src, out string, options Options) string {
+	t.Helper()
+	if err := Run(src, out, options); err != nil {
+		t.Fatal(err)
+	}
+	live, err := liveBuild(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return live
+}
+
+// rewritten lists the files in the build after that were written by it, rather than being
+// carried over from the build before.
+func rewritten(t *testing.T, before, after string) []string {
+	t.Helper()
+	var files []string
+	err := filepath.WalkDir(after, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(after, path)
+		if err != nil {
+			return err
+		}
+		was, err := os.Stat(filepath.Join(before, rel))
+		if err != nil {
+			files = append(files, filepath.ToSlash(rel))
+			return nil
+		}
+		is, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if !os.SameFile(was, is) {
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestOnlyAffectedPagesAreRebuilt(t *testing.T) {
+	src := fixtureSrc(t)
+	out := filepath.Join(t.TempDir(), "output")
+	before := runBuild(t, src, out, Options{SiteURL: testSite})
+
+	editFile(t, filepath.Join(src, "memories/harbour-walks.yaml"), "title: Walks Around the Harbour", "title: Harbour Walks")
+	changed := rewritten(t, before, runBuild(t, src, out, Options{SiteURL: testSite}))
+
+	if !slices.Contains(changed, "harbour-walks.html") {
+		t.Errorf("harbour-walks.html wasn't rebuilt, only %v", changed)
+	}
+	for _, page := range []string{"first-gig.html", "old-radio.html", "about.html", "style.css", "search.html", "songs/sunrise-the-fixtures.html", "artists/the-fixtures.html"} {
+		if slices.Contains(changed, page) {
+			t.Errorf("%s was rebuilt, but harbour-walks isn't on it", page)
+		}
+	}
+}
+
+func TestOnlyChangedAssetsAreCopied(t *testing.T) {
+	src := fixtureSrc(t)
+	writeFiles(t, src, map[string]string{"assets/notes.txt": "first draft"})
+	out := filepath.Join(t.TempDir(), "output")
+	before := runBuild(t, src, out, Options{SiteURL: testSite})
+
+	editFile(t, filepath.Join(src, "assets/notes.txt"), "first draft", "second draft")
+	changed := rewritten(t, before, runBuild(t, src, out, Options{SiteURL: testSite}))
+	if !slices.Equal(changed, []string{"assets/notes.txt"}) {
+		t.Errorf("rewrote %v, want just assets/notes.txt", changed)
+	}
+}
+
+func TestForceRebuildsEverything(t *testing.T) {
+	src := fixtureSrc(t)
+	out := filepath.Join(t.TempDir(), "output")
+	before := runBuild(t, src, out, Options{SiteURL: testSite})
+	after := runBuild(t, src, out, Options{SiteURL: testSite, Force: true})
+
+	all := rewritten(t, t.TempDir(), after)
+	if changed := rewritten(t, before, after); !slices.Equal(changed, all) {
+		t.Errorf("a forced build rewrote %d of %d files", len(changed), len(all))
+	}
+}
@@ -0,0 +1,106 @@
+package templater
+
+import (
+	"fmt"
+	"image"
+	"math"
+
+	"golang.org/x/image/draw"
+
+	sonostalgia "github.com/azoghal/sonostalgia/src"
+)
+
+const (
+	// paletteSample is the size images are shrunk to before counting colours, which is plenty
+	// to find the main colours and keeps the work the same for any size of image.
+	paletteSample = 48
+	// paletteBits is how many bits of each channel tell colours apart, so that near identical
+	// shades are counted together.
+	paletteBits = 4
+	// minAccentDistance is how far, in RGB, an accent must be from the dominant colour.
+	minAccentDistance = 96
+)
+
+type rgb [3]float64
+
+func (c rgb) hex() string {
+	return fmt.Sprintf("#%02x%02x%02x", uint8(math.Round(c[0])), uint8(math.Round(c[1])), uint8(math.Round(c[2])))
+}
+
+func (c rgb) distance(other rgb) float64 {
+	return math.Sqrt((c[0]-other[0])*(c[0]-other[0]) + (c[1]-other[1])*(c[1]-other[1]) + (c[2]-other[2])*(c[2]-other[2]))
+}
+
+// saturation is HSV saturation, from 0 for greys to 1 for pure colours.
+func (c rgb) saturation() float64 {
+	high, low := max(c[0], c[1], c[2]), min(c[0], c[1], c[2])
+	if high == 0 {
+		return 0
+	}
+	return (high - low) / high
+}
+
+func (c rgb) mix(other rgb, amount float64) rgb {
+	var mixed rgb
+	for i := range c {
+		mixed[i] = c[i]*(1-amount) + other[i]*amount
+	}
+	return mixed
+}
+
+// extractPalette takes the most common colour in img as its dominant colour, and for the
+// accent, the common colour that best stands out from it, favouring bright colours over greys.
+// Images of a single colour get a lighter or darker shade of it as their accent.
+func extractPalette(img image.Image) sonostalgia.Palette {
+	small := image.NewRGBA(image.Rect(0, 0, paletteSample, paletteSample))
+	draw.ApproxBiLinear.Scale(small, small.Bounds(), img, img.Bounds(), draw.Src, nil)
+
+	type bucket struct {
+		count int
+		sum   rgb
+	}
+	var buckets [1 << (3 * paletteBits)]bucket
+	for i := 0; i < len(small.Pix); i += 4 {
+		r, g, b := small.Pix[i], small.Pix[i+1], small.Pix[i+2]
+		key := int(r>>(8-paletteBits))<<(2*paletteBits) | int(g>>(8-paletteBits))<<paletteBits | int(b>>(8-paletteBits))
+		buckets[key].count++
+		buckets[key].sum[0] += float64(r)
+		buckets[key].sum[1] += float64(g)
+		buckets[key].sum[2] += float64(b)
+	}
+	mean := func(b bucket) rgb {
+		n := float64(b.count)
+		return rgb{b.sum[0] / n, b.sum[1] / n, b.sum[2] / n}
+	}
+
+	dominant := 0
+	for i, b := range buckets {
+		if b.count > buckets[dominant].count {
+			dominant = i
+		}
+	}
+	dominantColour := mean(buckets[dominant])
+
+	accent, bestScore := rgb{}, 0.0
+	for _, b := range buckets {
+		if b.count == 0 {
+			continue
+		}
+		colour := mean(b)
+		if colour.distance(dominantColour) < minAccentDistance {
+			continue
+		}
+		if score := float64(b.count) * (0.25 + colour.saturation()); score > bestScore {
+			accent, bestScore = colour, score
+		}
+	}
+	if bestScore == 0 {
+		shade := rgb{255, 255, 255}
+		if (dominantColour[0]+dominantColour[1]+dominantColour[2])/3 > 127 {
+			shade = rgb{0, 0, 0}
+		}
+		accent = dominantColour.mix(shade, 0.45)
+	}
+
+	return sonostalgia.Palette{Dominant: dominantColour.hex(), Accent: accent.hex()}
+}
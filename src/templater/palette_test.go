@@ -0,0 +1,44 @@
+package templater
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+func blocks(width int, colours ...color.RGBA) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width*len(colours), 100))
+	for i, c := range colours {
+		draw.Draw(img, image.Rect(i*width, 0, (i+1)*width, 100), image.NewUniform(c), image.Point{}, draw.Src)
+	}
+	return img
+}
+
+func TestExtractPalette(t *testing.T) {
+	red := color.RGBA{200, 30, 30, 255}
+	blue := color.RGBA{20, 40, 220, 255}
+	grey := color.RGBA{120, 120, 120, 255}
+	paleGrey := color.RGBA{130, 128, 126, 255}
+
+	tests := []struct {
+		name             string
+		img              image.Image
+		dominant, accent string
+	}{
+		// Three quarters red, so red dominates and blue is all that's left.
+		{"two colours", blocks(100, red, red, red, blue), "#c81e1e", "#1428dc"},
+		// The pale grey is as common as blue, but too close to the dominant grey to be the accent.
+		{"accent stands out", blocks(100, paleGrey, grey, grey, grey, blue), "#787878", "#1428dc"},
+		// With nothing else to choose, the accent is a lighter shade of the only colour.
+		{"one colour", blocks(100, grey), "#787878", "#b5b5b5"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := extractPalette(test.img)
+			if got.Dominant != test.dominant || got.Accent != test.accent {
+				t.Errorf("extractPalette = %+v, want dominant %s and accent %s", got, test.dominant, test.accent)
+			}
+		})
+	}
+}
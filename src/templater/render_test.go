@@ -0,0 +1,125 @@
+package templater
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRenderPagesCollectsErrors(t *testing.T) {
+	out := t.TempDir()
+	templates := template.Must(template.New("ok").Parse("{{.}}"))
+	template.Must(templates.New("broken").Parse("{{.Missing}}"))
+
+	none := newInputSet()
+	var pages []page
+	for i := range 20 {
+		p := page{templateName: "ok", outputName: fmt.Sprintf("page-%02d.html", i), templateParams: i, inputs: none}
+		if i%7 == 3 {
+			p.templateName = "broken"
+		}
+		pages = append(pages, p)
+	}
+	pages = append(pages,
+		page{templateName: "nonexistent", outputName: "no-template.html", inputs: none},
+		page{outputName: "custom.xml", inputs: none, render: func(io.Writer) error { return errors.New("boom") }},
+	)
+
+	next := newManifest()
+	err := renderPages(templates, out, pages, map[string]string{}, newManifest(), next, 4)
+	if err == nil {
+		t.Fatal("renderPages succeeded with broken pages")
+	}
+
+	msg := err.Error()
+	wantFailures := []string{"page-03.html", "page-10.html", "page-17.html", "no-template.html", "custom.xml"}
+	if !strings.HasPrefix(msg, fmt.Sprintf("%d of %d pages failed", len(wantFailures), len(pages))) {
+		t.Errorf("error starts %q", strings.SplitN(msg, "\n", 2)[0])
+	}
+	// Failures are reported in page order, however the workers finish.
+	last := -1
+	for _, name := range wantFailures {
+		at := strings.Index(msg, name)
+		if at < 0 {
+			t.Errorf("error doesn't mention %s:\n%s", name, msg)
+			continue
+		}
+		if at < last {
+			t.Errorf("%s is reported out of order:\n%s", name, msg)
+		}
+		last = at
+	}
+
+	// Good pages are still written and recorded; broken ones leave nothing behind.
+	for _, p := range pages {
+		_, statErr := os.Stat(filepath.Join(out, p.outputName))
+		_, recorded := next.Outputs[p.outputName]
+		failed := strings.Contains(msg, p.outputName)
+		if failed && (statErr == nil || recorded) {
+			t.Errorf("%s failed but was written or recorded", p.outputName)
+		}
+		if !failed && (statErr != nil || !recorded) {
+			t.Errorf("%s rendered but wasn't written or recorded", p.outputName)
+		}
+	}
+}
+
+// syntheticSrc writes n generated memories alongside the site's real templates and map.
+// Songs, artists, tags and people are drawn from pools so the aggregate pages have plenty to list.
+func syntheticSrc(b *testing.B, n int) string {
+	b.Helper()
+	src := b.TempDir()
+	copyTree(b, "../templates", filepath.Join(src, "templates"))
+	copyTree(b, "../map", filepath.Join(src, "map"))
+	memories := filepath.Join(src, "memories")
+	if err := os.MkdirAll(memories, 0755); err != nil {
+		b.Fatal(err)
+	}
+
+	seasons := []string{"Spring", "Summer", "Autumn", "Winter"}
+	for i := range n {
+		var yaml strings.Builder
+		fmt.Fprintf(&yaml, "outputTitle: memory-%05d\n", i)
+		fmt.Fprintf(&yaml, "title: Memory number %d\n", i)
+		fmt.Fprintf(&yaml, "subtitle: A synthetic memory for benchmarking\n")
+		fmt.Fprintf(&yaml, "date: \"%s %d\"\n", seasons[i%len(seasons)], 1990+i%35)
+		yaml.WriteString("songs:\n")
+		for j := range 3 {
+			song := (i*3 + j) % 1500
+			fmt.Fprintf(&yaml, "  - name: Song %d\n    link: https://open.spotify.com/track/%022d\n    artists:\n      - name: Artist %d\n", song, song, song%400)
+		}
+		fmt.Fprintf(&yaml, "tags:\n  - tag %d\n  - tag %d\n", i%60, (i+7)%60)
+		fmt.Fprintf(&yaml, "people:\n  - Person %d\n", i%150)
+		fmt.Fprintf(&yaml, "places:\n  - name: Place %d\n    lat: %.2f\n    lon: %.2f\n", i%90, float64(i%120)-60, float64(i%300)-150)
+		fmt.Fprintf(&yaml, "content: |\n  Memory **%d** happened.\n\n  - one\n  - two\n", i)
+
+		if err := os.WriteFile(filepath.Join(memories, fmt.Sprintf("memory-%05d.yaml", i)), []byte(yaml.String()), 0644); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return src
+}
+
+// BenchmarkRun times a full rebuild of 5,000 memories, with one worker and with four.
+func BenchmarkRun(b *testing.B) {
+	src := syntheticSrc(b, 5000)
+	log.SetOutput(io.Discard)
+	b.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	for _, workers := range []int{1, 4} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			out := b.TempDir()
+			for b.Loop() {
+				if err := Run(src, out, Options{Force: true, Workers: workers}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package templater
+
+import (
+	"encoding/json"
+	"html"
+	"io"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	sonostalgia "github.com/azoghal/sonostalgia/src"
+)
+
+// searchIndex is loaded by the search page, which does all the querying in the browser.
+// Terms map each lowercased word to the documents it appears in, so a query only has
+// to scan the text of memories that can match.
+type searchIndex struct {
+	Docs  []searchDoc      `json:"docs"`
+	Terms map[string][]int `json:"terms"`
+}
+
+type searchDoc struct {
+	Slug     string   `json:"slug"`
+	Title    string   `json:"title"`
+	Subtitle string   `json:"subtitle,omitempty"`
+	Date     string   `json:"date"`
+	Years    []int    `json:"years,omitempty"`
+	Image    string   `json:"image,omitempty"`
+	Songs    []string `json:"songs,omitempty"`
+	Artists  []string `json:"artists,omitempty"`
+	Content  string   `json:"content,omitempty"` // plain text, for snippets
+}
+
+var (
+	htmlTagRe    = regexp.MustCompile(`<[^>]*>`)
+	whitespaceRe = regexp.MustCompile(`\s+`)
+)
+
+func buildSearchIndex(memories []sonostalgia.Memory, images *coverImages) searchIndex {
+	index := searchIndex{Docs: make([]searchDoc, len(memories)), Terms: map[string][]int{}}
+	for i, memory := range memories {
+		doc := searchDoc{
+			Slug:     memory.OutputTitle,
+			Title:    memory.Title,
+			Subtitle: memory.Subtitle,
+			Date:     memory.Date.String(),
+			Years:    memory.Date.Years(),
+			Content:  plainText(memory.Content),
+		}
+		seenArtists := map[string]bool{}
+		for _, song := range slices.Concat(memory.Songs, memory.OtherSongs) {
+			doc.Songs = append(doc.Songs, song.Name)
+			if doc.Image == "" {
+				// Results show covers 80px wide.
+				doc.Image = images.cover(song.ImageLink, "", "", "").at(160)
+			}
+			for _, artist := range song.Artists {
+				if !seenArtists[artist.Name] {
+					seenArtists[artist.Name] = true
+					doc.Artists = append(doc.Artists, artist.Name)
+				}
+			}
+		}
+		index.Docs[i] = doc
+
+		fields := []string{doc.Title, doc.Subtitle, doc.Date, doc.Content}
+		fields = append(fields, doc.Songs...)
+		fields = append(fields, doc.Artists...)
+		for _, year := range doc.Years {
+			fields = append(fields, strconv.Itoa(year))
+		}
+		terms := map[string]bool{}
+		for _, field := range fields {
+			for _, term := range searchTerms(field) {
+				terms[term] = true
+			}
+		}
+		for term := range terms {
+			index.Terms[term] = append(index.Terms[term], i)
+		}
+	}
+	for _, docs := range index.Terms {
+		sort.Ints(docs)
+	}
+	return index
+}
+
+// searchTerms splits text into lowercased words. search.template.html splits queries the same way.
+func searchTerms(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// plainText renders markdown and strips it back down to text, so snippets don't show markup.
+func plainText(md string) string {
+	text := htmlTagRe.ReplaceAllString(renderMarkdown(md), " ")
+	return strings.TrimSpace(whitespaceRe.ReplaceAllString(html.UnescapeString(text), " "))
+}
+
+func writeSearchIndex(w io.Writer, index searchIndex) error {
+	return json.NewEncoder(w).Encode(index)
+}
@@ -2,11 +2,19 @@ package templater
 
 import (
 	"bytes"
+	"encoding/gob"
+	"errors"
 	"fmt"
 	"html/template"
+	"io"
+	"io/fs"
 	"log"
 	"os"
 	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
 
 	"github.com/yuin/goldmark"
 	"github.com/yuin/goldmark/extension"
@@ -18,15 +26,57 @@ type page struct {
 	templateName   string
 	outputName     string
 	templateParams any
+	inputs         *inputSet             // source files the page is built from, shared by pages with the same dependencies
+	render         func(io.Writer) error // writes pages that don't come from a template, like the feeds
 }
 
-func Run(srcDir, outputDir string) error {
+// Options tweak how Run builds the site.
+type Options struct {
+	// Force ignores the build manifest and rebuilds every page and asset.
+	Force bool
+	// Workers is how many pages are rendered at once. Zero means one per CPU.
+	Workers int
+	// SiteURL is where the site is published, e.g. https://example.com. Feeds need it for
+	// absolute links, and aren't written without it.
+	SiteURL string
+}
+
+// siteURLSource stands in for Options.SiteURL among the source hashes, so that feeds are rebuilt when it changes.
+const siteURLSource = ":site-url"
+
+// pageSourcePrefix starts the pseudo-sources that stand in for what a page is rendered from,
+// e.g. ":page:index.html", so that pages are only rebuilt when that changes.
+const pageSourcePrefix = ":page:"
+
+// Run builds the site from srcDir into outputDir. Unless opts.Force is set, only pages
+// and assets whose source files have changed since the last build are written. The site is
+// built in a staging directory and only replaces the live one if every page renders.
+func Run(srcDir, outputDir string, opts Options) error {
+	sources, err := sourceHashes(srcDir)
+	if err != nil {
+		return fmt.Errorf("hashing sources: %w", err)
+	}
+	sources[siteURLSource] = hashString(opts.SiteURL)
+
+	live, err := liveBuild(outputDir)
+	if err != nil {
+		return fmt.Errorf("finding the live build: %w", err)
+	}
+	previous := newManifest()
+	if !opts.Force && live != "" {
+		previous = loadManifest(live)
+	}
+	if live != "" && previous.covers(live, sources) {
+		log.Printf("Nothing has changed since the last build")
+		return nil
+	}
+
 	funcMap := template.FuncMap{
 		"markdown": func(md string) template.HTML {
-			var buf bytes.Buffer
-			goldmark.New(goldmark.WithExtensions(extension.Strikethrough)).Convert([]byte(md), &buf)
-			return template.HTML(buf.String())
+			return template.HTML(renderMarkdown(md))
 		},
+		"slugify": sonostalgia.Slugify,
+		"cover":   (&coverImages{}).cover, // replaced once the images have been processed
 		"statcard": func(label string, value any) sonostalgia.StatCard {
 			return sonostalgia.StatCard{Label: label, Value: value}
 		},
@@ -44,33 +94,110 @@ func Run(srcDir, outputDir string) error {
 		return fmt.Errorf("parsing templates: %w", err)
 	}
 
-	templateParams, err := loadMemories(filepath.Join(srcDir, "memories/*.yaml"))
+	problems, err := Lint(srcDir)
+	if err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		lines := make([]string, len(problems))
+		for i, p := range problems {
+			lines[i] = p.String()
+		}
+		return fmt.Errorf("found %d problems in memory files:\n%s", len(problems), strings.Join(lines, "\n"))
+	}
+
+	templateParams, err := loadMemories(memoryPattern(srcDir))
 	if err != nil {
 		return fmt.Errorf("parsing memories: %w", err)
 	}
 
-	if err := os.MkdirAll(outputDir, 0755); err != nil {
-		return fmt.Errorf("creating output directory: %w", err)
+	templateParams.MapParams.Land, err = loadLand(filepath.Join(srcDir, worldOutline), templateParams.MapParams.ViewBounds())
+	if err != nil {
+		return fmt.Errorf("loading map outline: %w", err)
+	}
+
+	if opts.SiteURL == "" {
+		log.Printf("Warning: no site URL set, so the feeds won't be written; pass --site-url or set SITE_URL")
+	}
+
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
 	}
 
-	if err := renderPages(htmlTemplates, outputDir, templateParams); err != nil {
+	images, err := processImages(srcDir, imageCacheDir(outputDir), templateParams.MemoryParams, sources, workers)
+	if err != nil {
 		return err
 	}
+	htmlTemplates.Funcs(template.FuncMap{"cover": images.cover})
+	images.setPalettes(templateParams.MemoryParams)
 
-	assetsIn := filepath.Join(srcDir, "assets")
-	if _, err := os.Stat(assetsIn); err == nil {
-		assetsOut := filepath.Join(outputDir, "assets")
-		if err := os.MkdirAll(assetsOut, 0755); err != nil {
-			return fmt.Errorf("creating assets output directory: %w", err)
-		}
-		if err := os.CopyFS(assetsOut, os.DirFS(assetsIn)); err != nil {
-			return fmt.Errorf("copying assets: %w", err)
-		}
+	staging, err := stage(outputDir, live, opts.Force)
+	if err != nil {
+		return fmt.Errorf("creating staging directory: %w", err)
+	}
+	if err := build(srcDir, staging, opts.SiteURL, workers, htmlTemplates, templateParams, images, sources, previous); err != nil {
+		removeBuild(staging)
+		return err
+	}
+	if err := publish(outputDir, staging); err != nil {
+		removeBuild(staging)
+		return fmt.Errorf("publishing build: %w", err)
 	}
+	return nil
+}
 
+// build writes the pages, assets and images that have changed since the previous build
+// into staging, which starts out as a copy of it, and a new manifest beside it.
+func build(srcDir, staging, siteURL string, workers int, htmlTemplates *template.Template, templateParams *sonostalgia.Sonostalgia, images *coverImages, sources map[string]string, previous *manifest) error {
+	next := newManifest()
+	pages, err := sitePages(srcDir, siteURL, templateParams, images, sources)
+	if err != nil {
+		return err
+	}
+	if err := renderPages(htmlTemplates, staging, pages, sources, previous, next, workers); err != nil {
+		return err
+	}
+
+	if err := copyAssets(srcDir, staging, sources, previous, next); err != nil {
+		return err
+	}
+
+	if err := writeImages(staging, images, sources, previous, next); err != nil {
+		return err
+	}
+
+	removeStaleOutputs(staging, previous, next)
+
+	next.Sources = sources
+	if err := next.save(staging); err != nil {
+		return fmt.Errorf("saving build manifest: %w", err)
+	}
 	return nil
 }
 
+// Lint validates every memory file under srcDir, returning all the problems found.
+func Lint(srcDir string) ([]sonostalgia.Problem, error) {
+	files, err := filepath.Glob(memoryPattern(srcDir))
+	if err != nil {
+		return nil, err
+	}
+	return sonostalgia.ValidateMemories(srcDir, files), nil
+}
+
+func memoryPattern(srcDir string) string {
+	return filepath.Join(srcDir, "memories/*.yaml")
+}
+
+// markdown is shared by every page; goldmark is safe to use from several workers at once.
+var markdown = goldmark.New(goldmark.WithExtensions(extension.Strikethrough))
+
+func renderMarkdown(md string) string {
+	var buf bytes.Buffer
+	markdown.Convert([]byte(md), &buf)
+	return buf.String()
+}
+
 func loadMemories(pattern string) (*sonostalgia.Sonostalgia, error) {
 	files, err := filepath.Glob(pattern)
 	if err != nil {
@@ -79,15 +206,53 @@ func loadMemories(pattern string) (*sonostalgia.Sonostalgia, error) {
 	return sonostalgia.LoadSonostalgia(files)
 }
 
-func renderPages(htmlTemplates *template.Template, outputDir string, templateParams *sonostalgia.Sonostalgia) error {
+// sitePages lists every page in the site along with the source files it depends on.
+// HTML templates can include each other, so every HTML page depends on all of them. Pages
+// rendered from params depend on those too, through a hash of them recorded among the
+// sources, and on the cover images they show, as the names of the resized copies they link
+// to change with the image. That way editing a memory only rebuilds the pages it appears on.
+func sitePages(srcDir, siteURL string, templateParams *sonostalgia.Sonostalgia, images *coverImages, sources map[string]string) ([]page, error) {
+	htmlTemplates := matching(sources, func(path string) bool {
+		return strings.HasPrefix(path, "templates/") && strings.HasSuffix(path, ".html")
+	})
+	memoryFiles := matching(sources, func(path string) bool {
+		return strings.HasPrefix(path, "memories/")
+	})
+
 	staticPages := []page{
-		{templateName: "style.css", outputName: "style.css"},
+		{templateName: "style.css", outputName: "style.css", inputs: newInputSet("templates/style.css")},
 		{templateName: "about.template.html", outputName: "about.html", templateParams: templateParams.AboutParams},
 		{templateName: "index.template.html", outputName: "index.html", templateParams: templateParams.IndexParams},
 		{templateName: "memories.template.html", outputName: "memories.html", templateParams: templateParams.MemoriesParams},
 		{templateName: "years.template.html", outputName: "years.html", templateParams: templateParams.YearsParams},
+		{templateName: "artists.template.html", outputName: "artists.html", templateParams: templateParams.ArtistsParams},
+		{templateName: "tags.template.html", outputName: "tags.html", templateParams: templateParams.TagsParams},
+		{templateName: "map.template.html", outputName: "map.html", templateParams: templateParams.MapParams},
+		{templateName: "search.template.html", outputName: "search.html", inputs: newInputSet(htmlTemplates...)},
+	}
+
+	// Artist, song and tag pages live in subdirectories, and set <base href="../"> so that links work as they do everywhere else.
+	allArtists := make([]page, len(templateParams.ArtistParams))
+	for i, artist := range templateParams.ArtistParams {
+		allArtists[i] = page{
+			templateName:   "artist.template.html",
+			outputName:     fmt.Sprintf("artists/%s.html", artist.Slug),
+			templateParams: artist,
+		}
 	}
 
+	allSongs := make([]page, len(templateParams.SongParams))
+	for i, song := range templateParams.SongParams {
+		allSongs[i] = page{
+			templateName:   "song.template.html",
+			outputName:     fmt.Sprintf("songs/%s.html", song.Song.Slug),
+			templateParams: song,
+		}
+	}
+
+	// Memory pages link to song and artist pages, whose slugs are handed out across every
+	// memory, so a song or artist added to one memory can change the slug of another's.
+	// The slugs are part of the params, so the page is rebuilt when its own change.
 	allMemories := make([]page, len(templateParams.MemoryParams))
 	for i, memory := range templateParams.MemoryParams {
 		allMemories[i] = page{
@@ -97,24 +262,230 @@ func renderPages(htmlTemplates *template.Template, outputDir string, templatePar
 		}
 	}
 
-	for _, p := range append(staticPages, allMemories...) {
-		t := htmlTemplates.Lookup(p.templateName)
-		log.Printf("Rendering template: %s", t.Name())
+	allTags := make([]page, len(templateParams.TagParams))
+	for i, tag := range templateParams.TagParams {
+		allTags[i] = page{
+			templateName:   "tag.template.html",
+			outputName:     fmt.Sprintf("%s/%s.html", tag.Dir(), tag.Slug),
+			templateParams: tag,
+		}
+	}
 
-		outputPath := filepath.Join(outputDir, p.outputName)
-		f, err := os.Create(outputPath)
-		if err != nil {
-			return fmt.Errorf("creating %s: %w", outputPath, err)
+	// Feeds include the size of each cover image, so they depend on the images too. Without
+	// a site URL their IDs and links couldn't be absolute, which both formats require, so
+	// they're left out, and any from a previous build are removed.
+	var feeds []page
+	if siteURL != "" {
+		feedInputs := newInputSet(slices.Concat(memoryFiles, images.links, []string{siteURLSource})...)
+		entries := feedEntries(srcDir, siteURL, templateParams.MemoryParams)
+		feeds = []page{
+			{
+				outputName: "feed.xml",
+				inputs:     feedInputs,
+				render:     func(w io.Writer) error { return writeAtom(w, siteURL, entries) },
+			},
+			{
+				outputName: "rss.xml",
+				inputs:     feedInputs,
+				render:     func(w io.Writer) error { return writeRSS(w, siteURL, entries) },
+			},
 		}
+	}
 
-		err = t.Execute(f, p.templateParams)
-		f.Close()
-		if err != nil {
-			return fmt.Errorf("executing template %s: %w", t.Name(), err)
+	searchIndex := buildSearchIndex(templateParams.MemoryParams, images)
+	search := page{
+		outputName: "search-index.json",
+		inputs:     newInputSet(slices.Concat(memoryFiles, images.links)...),
+		render:     func(w io.Writer) error { return writeSearchIndex(w, searchIndex) },
+	}
+
+	pages := slices.Concat(staticPages, allMemories, allArtists, allSongs, allTags, feeds, []page{search})
+	for i := range pages {
+		if pages[i].inputs != nil {
+			continue
+		}
+		var extra []string
+		if pages[i].outputName == "map.html" {
+			extra = []string{worldOutline}
+		}
+		if err := renderedFrom(&pages[i], htmlTemplates, images.links, sources, extra...); err != nil {
+			return nil, err
+		}
+	}
+	return pages, nil
+}
+
+// renderedFrom makes p depend on what it's rendered from: the HTML templates, its params,
+// whose hash is recorded in sources, the cover images they mention, and any extra sources.
+// The params are gob encoded rather than JSON encoded as the song and artist slugs and the
+// palettes, which pages show, are left out of JSON.
+func renderedFrom(p *page, htmlTemplates, imageLinks []string, sources map[string]string, extra ...string) error {
+	var params bytes.Buffer
+	if err := gob.NewEncoder(&params).Encode(p.templateParams); err != nil {
+		return fmt.Errorf("hashing the params of %s: %w", p.outputName, err)
+	}
+	source := pageSourcePrefix + p.outputName
+	sources[source] = hashString(params.String())
+
+	inputs := slices.Concat(htmlTemplates, []string{source}, extra)
+	for _, link := range imageLinks {
+		if bytes.Contains(params.Bytes(), []byte(link)) {
+			inputs = append(inputs, link)
+		}
+	}
+	p.inputs = newInputSet(inputs...)
+	return nil
+}
+
+// renderPages renders the pages that are out of date using opts.Workers goroutines. Every
+// page is attempted, and all the failures are reported together. Pages are logged in the
+// order they're listed, whichever finishes first, so the output of two builds can be compared.
+func renderPages(htmlTemplates *template.Template, outputDir string, pages []page, sources map[string]string, previous, next *manifest, workers int) error {
+	var stale []int
+	for i, p := range pages {
+		if !previous.upToDate(outputDir, p.outputName, p.inputs, sources) {
+			stale = append(stale, i)
+		}
+	}
+
+	errs := make([]error, len(pages))
+	todo := make(chan int)
+	var wg sync.WaitGroup
+	for range min(workers, len(stale)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range todo {
+				errs[i] = renderPage(htmlTemplates, outputDir, pages[i])
+			}
+		}()
+	}
+	for _, i := range stale {
+		todo <- i
+	}
+	close(todo)
+	wg.Wait()
+
+	// Outputs are recorded in page order too, so the manifest doesn't depend on which pages were stale.
+	var failed []error
+	for i, p := range pages {
+		if errs[i] != nil {
+			failed = append(failed, errs[i])
+			continue
+		}
+		next.record(p.outputName, p.inputs)
+	}
+	for _, i := range stale {
+		if errs[i] == nil {
+			log.Printf("Rendered: %s", pages[i].outputName)
+		}
+	}
+
+	if skipped := len(pages) - len(stale); skipped > 0 {
+		log.Printf("Skipped %d unchanged pages", skipped)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d pages failed to render:\n%w", len(failed), len(stale), errors.Join(failed...))
+	}
+	return nil
+}
+
+// renderPage writes a page only once it has rendered successfully, so a failure never leaves half a page behind.
+func renderPage(htmlTemplates *template.Template, outputDir string, p page) error {
+	render := p.render
+	if render == nil {
+		t := htmlTemplates.Lookup(p.templateName)
+		if t == nil {
+			return fmt.Errorf("rendering %s: no template named %s", p.outputName, p.templateName)
+		}
+		render = func(w io.Writer) error { return t.Execute(w, p.templateParams) }
+	}
+
+	var buf bytes.Buffer
+	if err := render(&buf); err != nil {
+		return fmt.Errorf("rendering %s: %w", p.outputName, err)
+	}
+
+	outputPath := filepath.Join(outputDir, filepath.FromSlash(p.outputName))
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+		return fmt.Errorf("creating directory for %s: %w", outputPath, err)
+	}
+	if err := replaceFile(outputPath, buf.Bytes()); err != nil {
+		return fmt.Errorf("writing %s: %w", outputPath, err)
+	}
+	return nil
+}
+
+// copyAssets copies new and changed files from srcDir/assets into outputDir/assets.
+func copyAssets(srcDir, outputDir string, sources map[string]string, previous, next *manifest) error {
+	copied := 0
+	for _, asset := range matching(sources, func(path string) bool { return strings.HasPrefix(path, "assets/") }) {
+		inputs := newInputSet(asset)
+		if previous.upToDate(outputDir, asset, inputs, sources) {
+			next.record(asset, inputs)
+			continue
 		}
 
-		log.Printf("Successfully created: %s", outputPath)
+		dest := filepath.Join(outputDir, filepath.FromSlash(asset))
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return fmt.Errorf("creating assets output directory: %w", err)
+		}
+		if err := copyFile(filepath.Join(srcDir, filepath.FromSlash(asset)), dest); err != nil {
+			return fmt.Errorf("copying asset %s: %w", asset, err)
+		}
+		next.record(asset, inputs)
+		copied++
 	}
 
+	if copied > 0 {
+		log.Printf("Copied %d assets", copied)
+	}
 	return nil
 }
+
+// removeStaleOutputs deletes files from the previous build that nothing produces any more,
+// e.g. the page of a memory that has been deleted.
+func removeStaleOutputs(outputDir string, previous, next *manifest) {
+	for output := range previous.Outputs {
+		if _, ok := next.Outputs[output]; ok {
+			continue
+		}
+		err := os.Remove(filepath.Join(outputDir, filepath.FromSlash(output)))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("failed to remove stale output %s: %v", output, err)
+			continue
+		}
+		log.Printf("Removed stale output: %s", output)
+	}
+}
+
+// replaceFile writes a new file at path rather than truncating the old one, which may be
+// hard linked into the live build.
+func replaceFile(path string, data []byte) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// copyFile copies src to a new file at dest, replacing rather than truncating any file already there.
+func copyFile(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	if err := os.Remove(dest); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
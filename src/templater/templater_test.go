@@ -0,0 +1,272 @@
+package templater
+
+import (
+	"bytes"
+	"flag"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata/golden from the current output")
+
+const (
+	fixtureDir = "testdata/src"
+	goldenDir  = "testdata/golden"
+	testSite   = "https://sonostalgia.example"
+)
+
+// fixtureSrc assembles a source tree from the fixture memories and assets and the site's real
+// templates and map, so that the golden files pin down what the templates actually produce.
+func fixtureSrc(t testing.TB) string {
+	t.Helper()
+	src := t.TempDir()
+	copyTree(t, fixtureDir, src)
+	copyTree(t, "../templates", filepath.Join(src, "templates"))
+	copyTree(t, "../map", filepath.Join(src, "map"))
+	return src
+}
+
+func copyTree(t testing.TB, from, to string) {
+	t.Helper()
+	err := filepath.WalkDir(from, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(from, path)
+		if err != nil {
+			return err
+		}
+		dest := filepath.Join(to, rel)
+		if d.IsDir() {
+			return os.MkdirAll(dest, 0755)
+		}
+		return copyFile(path, dest)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// renderedFiles lists the generated files worth comparing: everything but the copied
+// assets, which are just the fixtures again, and the resized images, which are up to the
+// image encoders.
+func renderedFiles(t *testing.T, dir string) map[string][]byte {
+	t.Helper()
+	// The output directory is a symlink to the live build, which WalkDir wouldn't follow.
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{}
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if strings.HasPrefix(rel, "assets/") || strings.HasPrefix(rel, "images/") {
+			return nil
+		}
+		files[rel], err = os.ReadFile(path)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestGolden(t *testing.T) {
+	src := fixtureSrc(t)
+	out := t.TempDir()
+	if err := Run(src, out, Options{SiteURL: testSite}); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	got := renderedFiles(t, out)
+
+	if *update {
+		if err := os.RemoveAll(goldenDir); err != nil {
+			t.Fatal(err)
+		}
+		for name, data := range got {
+			path := filepath.Join(goldenDir, filepath.FromSlash(name))
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, data, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		t.Logf("wrote %d golden files", len(got))
+		return
+	}
+
+	want := renderedFiles(t, goldenDir)
+	for name, data := range got {
+		golden, ok := want[name]
+		if !ok {
+			t.Errorf("%s was rendered but has no golden file; run go test ./src/templater -update", name)
+			continue
+		}
+		if !bytes.Equal(data, golden) {
+			t.Errorf("%s differs from its golden file at line %d; run go test ./src/templater -update if the change is intended", name, firstDifference(data, golden))
+		}
+	}
+	for name := range want {
+		if _, ok := got[name]; !ok {
+			t.Errorf("%s has a golden file but wasn't rendered", name)
+		}
+	}
+}
+
+// firstDifference returns the 1-based line on which a and b first differ.
+func firstDifference(a, b []byte) int {
+	line := 1
+	for i := 0; i < len(a) && i < len(b) && a[i] == b[i]; i++ {
+		if a[i] == '\n' {
+			line++
+		}
+	}
+	return line
+}
+
+func TestEveryPageIsRendered(t *testing.T) {
+	src := fixtureSrc(t)
+	out := t.TempDir()
+	if err := Run(src, out, Options{SiteURL: testSite}); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	sources, err := sourceHashes(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	params, err := loadMemories(memoryPattern(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pages, err := sitePages(src, testSite, params, &coverImages{}, sources)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]bool{}
+	for _, p := range pages {
+		expected[p.outputName] = true
+	}
+	// Every static page, whatever else is added to the site.
+	for _, name := range []string{"style.css", "about.html", "index.html", "memories.html", "years.html", "artists.html", "tags.html", "map.html", "search.html"} {
+		if !expected[name] {
+			t.Errorf("%s isn't in the site's pages", name)
+		}
+	}
+	// A page for every memory file.
+	memoryFiles, err := filepath.Glob(memoryPattern(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(memoryFiles) == 0 {
+		t.Fatal("no fixture memories")
+	}
+	for _, file := range memoryFiles {
+		name := strings.TrimSuffix(filepath.Base(file), ".yaml") + ".html"
+		if !expected[name] {
+			t.Errorf("%s has no page", file)
+		}
+	}
+
+	for name := range expected {
+		info, err := os.Stat(filepath.Join(out, filepath.FromSlash(name)))
+		if err != nil {
+			t.Errorf("%s wasn't rendered: %v", name, err)
+			continue
+		}
+		if info.Size() == 0 {
+			t.Errorf("%s is empty", name)
+		}
+	}
+}
+
+// Song and artist slugs are handed out across every memory, so adding a memory can change the
+// links on the pages of memories that haven't changed themselves.
+func TestAddingAMemoryRelinksOthers(t *testing.T) {
+	src := fixtureSrc(t)
+	out := t.TempDir()
+	if err := Run(src, out, Options{SiteURL: testSite}); err != nil {
+		t.Fatalf("first Run: %v", err)
+	}
+
+	// Without a Spotify link, this Sunrise is a different song to the one in first-gig, and
+	// takes its slug. THE FIXTURES is a different artist to The Fixtures, and sorts first.
+	writeFiles(t, src, map[string]string{
+		"memories/covers-night.yaml": "outputTitle: covers-night\ntitle: Covers Night\ndate: \"2021\"\n" +
+			"songs:\n  - name: Sunrise\n    artists:\n      - name: THE FIXTURES\n",
+	})
+	if err := Run(src, out, Options{SiteURL: testSite}); err != nil {
+		t.Fatalf("second Run: %v", err)
+	}
+
+	page := readOutput(t, out, "first-gig.html")
+	if !strings.Contains(page, `href="songs/sunrise-the-fixtures-2.html"`) {
+		t.Error("first-gig.html wasn't rebuilt to link to its song's new page")
+	}
+	if !strings.Contains(page, `href="artists/the-fixtures-2.html"`) {
+		t.Error("first-gig.html wasn't rebuilt to link to its artist's new page")
+	}
+	if song := readOutput(t, out, "songs/sunrise-the-fixtures-2.html"); !strings.Contains(song, "My First Gig") {
+		t.Error("songs/sunrise-the-fixtures-2.html isn't first-gig's Sunrise")
+	}
+	if artist := readOutput(t, out, "artists/the-fixtures-2.html"); !strings.Contains(artist, "Night Drive") {
+		t.Error("artists/the-fixtures-2.html isn't first-gig's The Fixtures")
+	}
+}
+
+func TestRenamingAnArtistRelinksOnlyItsMemories(t *testing.T) {
+	src := fixtureSrc(t)
+	out := filepath.Join(t.TempDir(), "output")
+	before := runBuild(t, src, out, Options{SiteURL: testSite})
+
+	// THE FIXTURES takes the-fixtures from first-gig's The Fixtures, but none of its songs' slugs.
+	writeFiles(t, src, map[string]string{
+		"memories/covers-night.yaml": "outputTitle: covers-night\ntitle: Covers Night\ndate: \"2021\"\n" +
+			"songs:\n  - name: Sunset\n    artists:\n      - name: THE FIXTURES\n",
+	})
+	changed := rewritten(t, before, runBuild(t, src, out, Options{SiteURL: testSite}))
+
+	if !slices.Contains(changed, "first-gig.html") {
+		t.Error("first-gig.html wasn't rebuilt for its artist's new slug")
+	} else if page := readOutput(t, out, "first-gig.html"); !strings.Contains(page, `href="artists/the-fixtures-2.html"`) {
+		t.Error("first-gig.html doesn't link to its artist's new page")
+	}
+	for _, page := range []string{"harbour-walks.html", "old-radio.html"} {
+		if slices.Contains(changed, page) {
+			t.Errorf("%s was rebuilt, but none of its artists were renamed", page)
+		}
+	}
+}
+
+func TestNoFeedsWithoutSiteURL(t *testing.T) {
+	src := fixtureSrc(t)
+	out := t.TempDir()
+	if err := Run(src, out, Options{SiteURL: testSite}); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if err := Run(src, out, Options{}); err != nil {
+		t.Fatalf("Run without a site URL: %v", err)
+	}
+	for _, feed := range []string{"feed.xml", "rss.xml"} {
+		if _, err := os.Stat(filepath.Join(out, feed)); err == nil {
+			t.Errorf("%s was written without a site URL", feed)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(out, "index.html")); err != nil {
+		t.Errorf("the rest of the site wasn't: %v", err)
+	}
+}
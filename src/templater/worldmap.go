@@ -0,0 +1,87 @@
+package templater
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	sonostalgia "github.com/azoghal/sonostalgia/src"
+)
+
+// worldOutline is the bundled country outline drawn under the map markers,
+// so the map page needs no third party tiles or scripts.
+const worldOutline = "map/world.geojson"
+
+type geoJSON struct {
+	Features []struct {
+		Geometry struct {
+			Type        string          `json:"type"`
+			Coordinates json.RawMessage `json:"coordinates"`
+		} `json:"geometry"`
+	} `json:"features"`
+}
+
+// loadLand converts the bundled outline into SVG path data, skipping any country that
+// falls entirely outside the map's view.
+func loadLand(path string, view sonostalgia.Bounds) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var world geoJSON
+	if err := json.Unmarshal(data, &world); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	var land []string
+	for _, feature := range world.Features {
+		var polygons [][][][2]float64
+		switch feature.Geometry.Type {
+		case "Polygon":
+			var polygon [][][2]float64
+			if err := json.Unmarshal(feature.Geometry.Coordinates, &polygon); err != nil {
+				return nil, fmt.Errorf("parsing %s: %w", path, err)
+			}
+			polygons = [][][][2]float64{polygon}
+		case "MultiPolygon":
+			if err := json.Unmarshal(feature.Geometry.Coordinates, &polygons); err != nil {
+				return nil, fmt.Errorf("parsing %s: %w", path, err)
+			}
+		default:
+			continue
+		}
+
+		if d, ok := svgPath(polygons, view); ok {
+			land = append(land, d)
+		}
+	}
+	return land, nil
+}
+
+// svgPath draws every ring of every polygon, reporting false if none of it is in view.
+func svgPath(polygons [][][][2]float64, view sonostalgia.Bounds) (string, bool) {
+	var b strings.Builder
+	bounds := sonostalgia.Bounds{MinX: math.Inf(1), MinY: math.Inf(1), MaxX: math.Inf(-1), MaxY: math.Inf(-1)}
+	for _, polygon := range polygons {
+		for _, ring := range polygon {
+			for i, point := range ring {
+				x, y := sonostalgia.Project(point[1], point[0])
+				bounds.MinX, bounds.MaxX = math.Min(bounds.MinX, x), math.Max(bounds.MaxX, x)
+				bounds.MinY, bounds.MaxY = math.Min(bounds.MinY, y), math.Max(bounds.MaxY, y)
+				if i == 0 {
+					b.WriteByte('M')
+				} else {
+					b.WriteByte('L')
+				}
+				b.WriteString(strconv.FormatFloat(x, 'f', 1, 64))
+				b.WriteByte(',')
+				b.WriteString(strconv.FormatFloat(y, 'f', 1, 64))
+			}
+			b.WriteByte('Z')
+		}
+	}
+	return b.String(), bounds.Intersects(view)
+}
@@ -0,0 +1,359 @@
+package sonostalgia
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Problem is a single issue found in a memory file.
+type Problem struct {
+	File    string
+	Line    int // 0 if the problem isn't tied to a line
+	Message string
+}
+
+func (p Problem) String() string {
+	if p.Line == 0 {
+		return fmt.Sprintf("%s: %s", p.File, p.Message)
+	}
+	return fmt.Sprintf("%s:%d: %s", p.File, p.Line, p.Message)
+}
+
+var (
+	memoryKeys  = yamlKeys(reflect.TypeOf(Memory{}))
+	songKeys    = yamlKeys(reflect.TypeOf(Song{}))
+	artistKeys  = yamlKeys(reflect.TypeOf(Artist{}))
+	placeKeys   = yamlKeys(reflect.TypeOf(Place{}))
+	paletteKeys = yamlKeys(reflect.TypeOf(Palette{}))
+
+	requiredMemoryKeys = []string{"outputTitle", "title", "date"}
+
+	yamlLineRe  = regexp.MustCompile(`line (\d+):`)
+	hexColourRe = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+)
+
+// ValidateMemories checks every memory file and reports all the problems found,
+// rather than stopping at the first. imageLinks are resolved relative to srcDir.
+func ValidateMemories(srcDir string, memoryFiles []string) []Problem {
+	var problems []Problem
+	seenTitles := map[string]string{}
+
+	for _, file := range memoryFiles {
+		outputTitle, fileProblems := validateMemoryFile(srcDir, file)
+		problems = append(problems, fileProblems...)
+
+		if outputTitle == "" {
+			continue
+		}
+		if other, ok := seenTitles[outputTitle]; ok {
+			problems = append(problems, Problem{
+				File:    file,
+				Message: fmt.Sprintf("outputTitle %q is already used by %s", outputTitle, other),
+			})
+			continue
+		}
+		seenTitles[outputTitle] = file
+	}
+
+	return problems
+}
+
+// ValidateMemory checks data, which is about to be written to file, as ValidateMemories
+// would, including that none of the other memoryFiles has the same outputTitle. Problems in
+// the other files aren't reported, so a memory can be checked before it's saved.
+func ValidateMemory(srcDir, file string, data []byte, memoryFiles []string) []Problem {
+	outputTitle, problems := validateMemory(srcDir, file, data)
+	if outputTitle == "" {
+		return problems
+	}
+	for _, other := range memoryFiles {
+		if filepath.Clean(other) == filepath.Clean(file) {
+			continue
+		}
+		otherData, err := os.ReadFile(other)
+		if err != nil {
+			continue
+		}
+		var fields struct {
+			OutputTitle string `yaml:"outputTitle"`
+		}
+		if yaml.Unmarshal(otherData, &fields) == nil && fields.OutputTitle == outputTitle {
+			problems = append(problems, Problem{
+				File:    file,
+				Message: fmt.Sprintf("outputTitle %q is already used by %s", outputTitle, other),
+			})
+		}
+	}
+	return problems
+}
+
+func validateMemoryFile(srcDir, file string) (string, []Problem) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", []Problem{{File: file, Message: err.Error()}}
+	}
+	return validateMemory(srcDir, file, data)
+}
+
+// validateMemory works on the raw yaml nodes so that line numbers are available,
+// and so one bad field doesn't hide problems in the rest of the file.
+func validateMemory(srcDir, file string, data []byte) (string, []Problem) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		line := 0
+		if m := yamlLineRe.FindStringSubmatch(err.Error()); m != nil {
+			fmt.Sscan(m[1], &line)
+		}
+		return "", []Problem{{File: file, Line: line, Message: err.Error()}}
+	}
+
+	v := validator{file: file, srcDir: srcDir}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		v.add(doc.Line, "expected a mapping of memory fields")
+		return "", v.problems
+	}
+	root := doc.Content[0]
+
+	fields := v.mapping(root, memoryKeys, "memory")
+	for _, key := range requiredMemoryKeys {
+		if value, ok := fields[key]; !ok {
+			v.add(root.Line, "missing required field %q", key)
+		} else if isBlank(value) {
+			v.add(value.Line, "required field %q is empty", key)
+		}
+	}
+
+	outputTitle := ""
+	if value, ok := fields["outputTitle"]; ok && !isBlank(value) {
+		outputTitle = value.Value
+		slug := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+		if outputTitle != slug {
+			v.add(value.Line, "outputTitle %q doesn't match the file name %q", outputTitle, slug)
+		}
+	}
+
+	if value, ok := fields["date"]; ok {
+		v.date(value)
+	}
+	if value, ok := fields["published"]; ok && !isBlank(value) {
+		var published time.Time
+		if err := value.Decode(&published); err != nil {
+			v.add(value.Line, "published must be a timestamp like 2025-06-01T18:30:00Z")
+		}
+	}
+	for _, key := range []string{"songs", "otherSongs"} {
+		if value, ok := fields[key]; ok {
+			v.songs(value, key)
+		}
+	}
+	for _, key := range []string{"tags", "people"} {
+		if value, ok := fields[key]; ok {
+			v.labels(value, key)
+		}
+	}
+	if value, ok := fields["places"]; ok {
+		v.places(value)
+	}
+	if value, ok := fields["palette"]; ok {
+		v.palette(value)
+	}
+
+	sort.SliceStable(v.problems, func(i, j int) bool { return v.problems[i].Line < v.problems[j].Line })
+	return outputTitle, v.problems
+}
+
+type validator struct {
+	file     string
+	srcDir   string
+	problems []Problem
+}
+
+func (v *validator) add(line int, format string, args ...any) {
+	v.problems = append(v.problems, Problem{File: v.file, Line: line, Message: fmt.Sprintf(format, args...)})
+}
+
+// mapping returns the value nodes of a mapping by key, reporting any keys not in known.
+func (v *validator) mapping(node *yaml.Node, known map[string]bool, what string) map[string]*yaml.Node {
+	fields := map[string]*yaml.Node{}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if !known[key.Value] {
+			v.add(key.Line, "unknown %s field %q", what, key.Value)
+			continue
+		}
+		if _, ok := fields[key.Value]; ok {
+			v.add(key.Line, "%s field %q is set more than once", what, key.Value)
+		}
+		fields[key.Value] = value
+	}
+	return fields
+}
+
+func (v *validator) date(node *yaml.Node) {
+	if node.Kind != yaml.ScalarNode {
+		v.add(node.Line, "date must be a single value")
+		return
+	}
+	if isBlank(node) {
+		return
+	}
+	if _, err := ParseDateSpec(node.Value); err != nil {
+		v.add(node.Line, "%v", err)
+	}
+}
+
+func (v *validator) songs(node *yaml.Node, key string) {
+	if isBlank(node) {
+		return
+	}
+	if node.Kind != yaml.SequenceNode {
+		v.add(node.Line, "%s must be a list", key)
+		return
+	}
+
+	for i, songNode := range node.Content {
+		if songNode.Kind != yaml.MappingNode {
+			v.add(songNode.Line, "%s[%d] must be a mapping", key, i)
+			continue
+		}
+		song := v.mapping(songNode, songKeys, "song")
+
+		if name, ok := song["name"]; !ok || isBlank(name) {
+			v.add(songNode.Line, "%s[%d] has no name", key, i)
+		}
+
+		artists, ok := song["artists"]
+		if !ok || isBlank(artists) || len(artists.Content) == 0 {
+			v.add(songNode.Line, "%s[%d] has no artists", key, i)
+		} else if artists.Kind != yaml.SequenceNode {
+			v.add(artists.Line, "%s[%d].artists must be a list", key, i)
+		} else {
+			for j, artistNode := range artists.Content {
+				if artistNode.Kind != yaml.MappingNode {
+					v.add(artistNode.Line, "%s[%d].artists[%d] must be a mapping", key, i, j)
+					continue
+				}
+				artist := v.mapping(artistNode, artistKeys, "artist")
+				if name, ok := artist["name"]; !ok || isBlank(name) {
+					v.add(artistNode.Line, "%s[%d].artists[%d] has no name", key, i, j)
+				}
+			}
+		}
+
+		if date, ok := song["relevantDate"]; ok {
+			v.date(date)
+		}
+
+		if image, ok := song["imageLink"]; ok && !isBlank(image) && !isRemote(image.Value) {
+			if _, err := os.Stat(filepath.Join(v.srcDir, image.Value)); err != nil {
+				v.add(image.Line, "imageLink %q doesn't exist", image.Value)
+			}
+		}
+	}
+}
+
+// labels checks a list of plain strings, like tags or people.
+func (v *validator) labels(node *yaml.Node, key string) {
+	if isBlank(node) {
+		return
+	}
+	if node.Kind != yaml.SequenceNode {
+		v.add(node.Line, "%s must be a list", key)
+		return
+	}
+	for i, label := range node.Content {
+		if label.Kind != yaml.ScalarNode || isBlank(label) {
+			v.add(label.Line, "%s[%d] must be a non-empty string", key, i)
+		}
+	}
+}
+
+func (v *validator) places(node *yaml.Node) {
+	if isBlank(node) {
+		return
+	}
+	if node.Kind != yaml.SequenceNode {
+		v.add(node.Line, "places must be a list")
+		return
+	}
+	for i, placeNode := range node.Content {
+		if placeNode.Kind != yaml.MappingNode {
+			v.add(placeNode.Line, "places[%d] must be a mapping", i)
+			continue
+		}
+		place := v.mapping(placeNode, placeKeys, "place")
+		if name, ok := place["name"]; !ok || isBlank(name) {
+			v.add(placeNode.Line, "places[%d] has no name", i)
+		}
+		v.coordinate(placeNode, place["lat"], fmt.Sprintf("places[%d].lat", i), 90)
+		v.coordinate(placeNode, place["lon"], fmt.Sprintf("places[%d].lon", i), 180)
+	}
+}
+
+func (v *validator) palette(node *yaml.Node) {
+	if isBlank(node) {
+		return
+	}
+	if node.Kind != yaml.MappingNode {
+		v.add(node.Line, "palette must be a mapping")
+		return
+	}
+	for key, value := range v.mapping(node, paletteKeys, "palette") {
+		if value.Kind != yaml.ScalarNode || !hexColourRe.MatchString(value.Value) {
+			v.add(value.Line, "palette.%s must be a hex colour like \"#1a2b3c\"", key)
+		}
+	}
+}
+
+func (v *validator) coordinate(parent, node *yaml.Node, what string, limit float64) {
+	if node == nil || isBlank(node) {
+		v.add(parent.Line, "%s is missing", what)
+		return
+	}
+	value, err := strconv.ParseFloat(node.Value, 64)
+	if err != nil || node.Kind != yaml.ScalarNode {
+		v.add(node.Line, "%s must be a number", what)
+		return
+	}
+	if value < -limit || value > limit {
+		v.add(node.Line, "%s must be between -%g and %g", what, limit, limit)
+	}
+}
+
+func isBlank(node *yaml.Node) bool {
+	return node.Tag == "!!null" || (node.Kind == yaml.ScalarNode && strings.TrimSpace(node.Value) == "")
+}
+
+func isRemote(link string) bool {
+	return strings.HasPrefix(link, "http://") || strings.HasPrefix(link, "https://")
+}
+
+// yamlKeys lists the keys yaml.v3 will decode into t, honouring yaml tags
+// and falling back to the lowercased field name like the decoder does.
+func yamlKeys(t reflect.Type) map[string]bool {
+	keys := map[string]bool{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		keys[name] = true
+	}
+	return keys
+}
@@ -0,0 +1,169 @@
+package sonostalgia
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func writeMemoryFiles(t *testing.T, dir string, files map[string]string) []string {
+	t.Helper()
+	var paths []string
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasPrefix(name, "memories/") {
+			paths = append(paths, path)
+		}
+	}
+	slices.Sort(paths)
+	return paths
+}
+
+const validSong = "songs:\n  - name: Sunrise\n    artists:\n      - name: The Fixtures\n"
+
+func TestValidateMemories(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  []string // the problems expected, as "file:line: message" without the directory
+	}{
+		{
+			name: "valid",
+			files: map[string]string{
+				"memories/gig.yaml": "outputTitle: gig\ntitle: Gig\ndate: Summer 2019\n" + validSong +
+					"    imageLink: assets/sunrise.jpg\nplaces:\n  - name: Brixton\n    lat: 51.46\n    lon: -0.11\n",
+				"assets/sunrise.jpg": "cover",
+			},
+		},
+		{
+			name:  "missing fields",
+			files: map[string]string{"memories/gig.yaml": "outputTitle: gig\ntitle: \"\"\n"},
+			want: []string{
+				`gig.yaml:1: missing required field "date"`,
+				`gig.yaml:2: required field "title" is empty`,
+			},
+		},
+		{
+			name:  "unknown and repeated fields",
+			files: map[string]string{"memories/gig.yaml": "outputTitle: gig\ntitle: Gig\ntitle: Gig again\ndate: \"2019\"\nmood: loud\n"},
+			want: []string{
+				`gig.yaml:3: memory field "title" is set more than once`,
+				`gig.yaml:5: unknown memory field "mood"`,
+			},
+		},
+		{
+			name:  "bad dates",
+			files: map[string]string{"memories/gig.yaml": "outputTitle: gig\ntitle: Gig\ndate: last summer\n" + validSong + "    relevantDate: June 2019 to May 2019\n"},
+			want: []string{
+				`gig.yaml:3: unrecognised date "last summer"`,
+				`gig.yaml:8: unrecognised date "June 2019 to May 2019"`,
+			},
+		},
+		{
+			name: "duplicate slugs",
+			files: map[string]string{
+				"memories/gig.yaml":   "outputTitle: gig\ntitle: Gig\ndate: \"2019\"\n",
+				"memories/other.yaml": "outputTitle: gig\ntitle: Other\ndate: \"2019\"\n",
+			},
+			want: []string{
+				`other.yaml:1: outputTitle "gig" doesn't match the file name "other"`,
+				`other.yaml: outputTitle "gig" is already used by `,
+			},
+		},
+		{
+			name: "missing images",
+			files: map[string]string{"memories/gig.yaml": "outputTitle: gig\ntitle: Gig\ndate: \"2019\"\n" + validSong +
+				"    imageLink: assets/missing.jpg\n  - name: Remote\n    artists:\n      - name: Elsewhere\n    imageLink: https://example.com/cover.jpg\n"},
+			want: []string{`gig.yaml:8: imageLink "assets/missing.jpg" doesn't exist`},
+		},
+		{
+			name: "bad songs",
+			files: map[string]string{"memories/gig.yaml": "outputTitle: gig\ntitle: Gig\ndate: \"2019\"\n" +
+				"songs:\n  - name: Sunrise\n  - artists:\n      - link: https://example.com\n"},
+			want: []string{
+				`gig.yaml:5: songs[0] has no artists`,
+				`gig.yaml:6: songs[1] has no name`,
+				`gig.yaml:7: songs[1].artists[0] has no name`,
+			},
+		},
+		{
+			name: "bad places",
+			files: map[string]string{"memories/gig.yaml": "outputTitle: gig\ntitle: Gig\ndate: \"2019\"\nplaces:\n" +
+				"  - name: \"\"\n    lat: 91\n    lon: -0.11\n" +
+				"  - name: Nowhere\n    lat: north\n" +
+				"  - name: Dateline\n    lat: 0\n    lon: 180.5\n"},
+			want: []string{
+				`gig.yaml:5: places[0] has no name`,
+				`gig.yaml:6: places[0].lat must be between -90 and 90`,
+				`gig.yaml:8: places[1].lon is missing`,
+				`gig.yaml:9: places[1].lat must be a number`,
+				`gig.yaml:12: places[2].lon must be between -180 and 180`,
+			},
+		},
+		{
+			name:  "bad palette",
+			files: map[string]string{"memories/gig.yaml": "outputTitle: gig\ntitle: Gig\ndate: \"2019\"\npalette:\n  accent: red\n"},
+			want:  []string{`gig.yaml:5: palette.accent must be a hex colour like "#1a2b3c"`},
+		},
+		{
+			name:  "not yaml",
+			files: map[string]string{"memories/gig.yaml": "outputTitle: gig\ntitle: [unclosed\n"},
+			want:  []string{`gig.yaml:`},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			files := writeMemoryFiles(t, dir, test.files)
+			problems := ValidateMemories(dir, files)
+
+			var got []string
+			for _, p := range problems {
+				got = append(got, strings.TrimPrefix(p.String(), filepath.Join(dir, "memories")+string(filepath.Separator)))
+			}
+			if len(got) != len(test.want) {
+				t.Fatalf("got %d problems, want %d:\n%s", len(got), len(test.want), strings.Join(got, "\n"))
+			}
+			for i, want := range test.want {
+				if !strings.HasPrefix(got[i], want) {
+					t.Errorf("problem %d = %q, want it to start %q", i, got[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateMemory(t *testing.T) {
+	dir := t.TempDir()
+	files := writeMemoryFiles(t, dir, map[string]string{
+		"memories/eve.yaml":   "outputTitle: eve-online\ntitle: Eve\ndate: \"2019\"\n",
+		"memories/gig.yaml":   "outputTitle: gig\ntitle: Gig\ndate: \"2019\"\n",
+		"memories/other.yaml": "outputTitle: [broken\n",
+	})
+
+	// Saving over a memory's own file is fine, and problems elsewhere aren't reported.
+	gig := filepath.Join(dir, "memories/gig.yaml")
+	if problems := ValidateMemory(dir, gig, []byte("outputTitle: gig\ntitle: Gig again\ndate: \"2020\"\n"), files); len(problems) != 0 {
+		t.Errorf("resaving gig: %v", problems)
+	}
+
+	problems := ValidateMemory(dir, filepath.Join(dir, "memories/eve-online.yaml"),
+		[]byte("outputTitle: eve-online\ntitle: Eve\ndate: someday\n"), files)
+	var got []string
+	for _, p := range problems {
+		got = append(got, p.Message)
+	}
+	want := []string{`unrecognised date "someday"`, `outputTitle "eve-online" is already used by ` + files[0]}
+	if !slices.Equal(got, want) {
+		t.Errorf("problems = %q, want %q", got, want)
+	}
+}